@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/catatsuy/private-isu/benchmarker/bench"
+)
+
+const GenerateAssetManifestCommand = "generate-asset-manifest"
+
+// webapp/public以下のファイルからassets.jsonを生成する
+// ファイル名にハッシュを付けたりまとめたりしたときは、生成し直してuserdataに置けばよい
+func (cli *CLI) runGenerateAssetManifest(args []string) int {
+	var (
+		public string
+		output string
+	)
+
+	flags := flag.NewFlagSet(GenerateAssetManifestCommand, flag.ContinueOnError)
+	flags.SetOutput(cli.errStream)
+
+	flags.StringVar(&public, "public", "../webapp/public", "document root of the webapp")
+	flags.StringVar(&output, "output", "", "output file (default: stdout)")
+	flags.StringVar(&output, "o", "", "output file (Short)")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return ExitCodeError
+	}
+
+	digests, err := bench.GenerateAssetManifest(public)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+
+	b, err := json.MarshalIndent(digests, "", "  ")
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+	b = append(b, '\n')
+
+	if output == "" {
+		cli.outStream.Write(b)
+		return ExitCodeOK
+	}
+
+	err = os.WriteFile(output, b, 0644)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+
+	return ExitCodeOK
+}
@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// userdataディレクトリに置く静的ファイルのmanifest
+const AssetManifestFile = "assets.json"
+
+// 静的ファイルのURLのパスと期待するMD5
+// manifestがないときはwebapp/publicの初期状態のものを使う
+func defaultAssetDigests() map[string]string {
+	return map[string]string{
+		"/favicon.ico":         "ad4b0f606e0f8465bc4c4c170b37e1a3",
+		"/css/style.css":       "e4c3606a18d11863189405eb5c6ca551",
+		"/js/timeago.min.js":   "f2d4c53400d0a46de704f5a97d6d04fb",
+		"/js/main.js":          "9c309fed7e360c57a705978dab2c68ad",
+		"/img/ajax-loader.gif": "2a6692973429d7a74513bfa8bcb5be20",
+	}
+}
+
+// userdataディレクトリにassets.jsonがあれば読み込む。なければ初期状態のものを返す
+func loadAssetManifest(userdata string) (map[string]string, error) {
+	b, err := os.ReadFile(filepath.Join(userdata, AssetManifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultAssetDigests(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digests := map[string]string{}
+	err = json.Unmarshal(b, &digests)
+	if err != nil {
+		return nil, fmt.Errorf("%s が読み込めません: %s", AssetManifestFile, err)
+	}
+	return digests, nil
+}
+
+// webapp/public以下のファイルから、URLのパスとMD5の対応を作る
+// ファイル名にハッシュを付けたりまとめたりしたときは、生成し直してuserdataのassets.jsonに置けばよい
+func GenerateAssetManifest(public string) (map[string]string, error) {
+	digests := map[string]string{}
+
+	err := filepath.WalkDir(public, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(public, p)
+		if err != nil {
+			return err
+		}
+		digests["/"+filepath.ToSlash(rel)] = util.GetMD5(b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// ブラウザと同じようにページが読み込む静的ファイルのURLを集める
+// 投稿画像(img.isu-image)はloadImagesで読み込むので含めない
+// 別のホストにあるものやdata URIは対象にしない
+func extractAssets(doc *goquery.Document, pagePath string) []string {
+	base, err := url.Parse(pagePath)
+	if err != nil {
+		base = &url.URL{Path: "/"}
+	}
+
+	assetURLs := []string{}
+	seen := map[string]bool{}
+	hasIcon := false
+
+	add := func(ref string) {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil || ref == "" || u.Host != "" || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+			return
+		}
+		u = base.ResolveReference(u)
+		u.Fragment = ""
+		p := u.RequestURI()
+		if seen[p] {
+			return
+		}
+		seen[p] = true
+		assetURLs = append(assetURLs, p)
+	}
+
+	doc.Find("link[href]").Each(func(_ int, selection *goquery.Selection) {
+		rels := strings.Fields(strings.ToLower(selection.AttrOr("rel", "")))
+		for _, rel := range rels {
+			switch rel {
+			case "stylesheet":
+				add(selection.AttrOr("href", ""))
+			case "icon":
+				hasIcon = true
+				add(selection.AttrOr("href", ""))
+			}
+		}
+	})
+
+	doc.Find("script[src]").Each(func(_ int, selection *goquery.Selection) {
+		add(selection.AttrOr("src", ""))
+	})
+
+	doc.Find("img[src]").Not(".isu-image").Each(func(_ int, selection *goquery.Selection) {
+		add(selection.AttrOr("src", ""))
+	})
+
+	// アイコンの指定がなければブラウザは/favicon.icoを読みに行く
+	if !hasIcon {
+		add("/favicon.ico")
+	}
+
+	return assetURLs
+}
+
+// manifestにあるものはMD5を確認し、ないものは読み込めることだけを確認する
+func loadAssets(ctx context.Context, s *checker.Session, assetURLs []string) {
+	assetDigests := runStateFromContext(ctx).assetDigests
+	for _, assetURL := range assetURLs {
+		asset := &checker.Asset{}
+		p := assetURL
+		if u, err := url.Parse(assetURL); err == nil {
+			p = u.Path
+		}
+		if md5, ok := assetDigests[p]; ok {
+			asset.MD5 = md5
+		}
+
+		a := checker.NewAssetAction(assetURL, asset)
+		a.Description = path.Base(p) + "が読み込めること"
+		a.Play(ctx, s)
+	}
+}
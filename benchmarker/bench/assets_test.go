@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func TestExtractAssets(t *testing.T) {
+	html := `<html><head>
+<link href="/css/style.3f2a.css" rel="stylesheet">
+<link href="/css/style.3f2a.css" rel="stylesheet">
+<link href="https://cdn.example.com/lib.css" rel="stylesheet">
+<link href="/feed" rel="alternate">
+</head><body>
+<img class="isu-loading-icon" src="/img/ajax-loader.gif">
+<img class="isu-image" src="/image/1.jpg">
+<img src="data:image/gif;base64,R0lGODlhAQABAAAAACw=">
+<script src="js/app.js?v=2"></script>
+<script>console.log("inline")</script>
+</body></html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := extractAssets(doc, "/posts/1")
+	expected := []string{"/css/style.3f2a.css", "/posts/js/app.js?v=2", "/img/ajax-loader.gif", "/favicon.ico"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(`<link rel="shortcut icon" href="/icon.png">`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = extractAssets(doc, "/")
+	if !reflect.DeepEqual(got, []string{"/icon.png"}) {
+		t.Errorf("favicon.ico should not be requested when an icon is specified, got %v", got)
+	}
+}
+
+func TestGenerateAssetManifest(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"css/style.css": "body{}", ".gitkeep": "", "favicon.ico": "icon"} {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digests, err := GenerateAssetManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/css/style.css": util.GetMD5([]byte("body{}")),
+		"/favicon.ico":   util.GetMD5([]byte("icon")),
+	}
+	if !reflect.DeepEqual(digests, expected) {
+		t.Errorf("expected %v, got %v", expected, digests)
+	}
+}
@@ -0,0 +1,630 @@
+// Package bench はprivate-isuのwebappをベンチマークする
+//
+// 自分のテストやツールから使うときは、DefaultConfigを書き換えてRunを呼ぶ
+//
+//	c := bench.DefaultConfig()
+//	c.Target = "http://localhost:8080"
+//	c.Userdata = "userdata"
+//	c.Scenarios = []bench.Scenario{{Name: "myScenario", Concurrency: 1, Run: myScenario}}
+//	result, err := bench.Run(ctx, c)
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/cache"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+const (
+	FailThreshold     = 5 // 失敗の割合(%)がこれを超えたら失格
+	MinSuccess        = 1
+	InitializeTimeout = time.Duration(10) * time.Second
+	BenchmarkTimeout  = 60 * time.Second
+	WaitAfterTimeout  = 10 * time.Second
+	DrainTimeout      = 5 * time.Second
+	AuditSamples      = 20
+	FreshnessWindow   = 3 * time.Second
+
+	PostsPerPage = 20
+)
+
+// ベンチマークの設定
+// DefaultConfigで作ったものを書き換えて使う
+type Config struct {
+	// ベンチマークするwebappのURL
+	Target string
+	// ユーザーや投稿する画像を置いたディレクトリ
+	Userdata string
+
+	BenchmarkTimeout time.Duration
+	WaitAfterTimeout time.Duration
+	// 負荷走行の最初にスコアに含めずに負荷をかける時間
+	Warmup time.Duration
+	// 中断したときに実行中のリクエストを待つ時間
+	DrainTimeout time.Duration
+	// 新しい投稿がインデックスページと「もっと見る」に表示されるまで待つ時間
+	FreshnessWindow time.Duration
+
+	// ベンチマーク後に確認する、作成したユーザーと投稿の数。0なら確認しない
+	AuditSamples int
+
+	Rules DisqualifyRules
+	// nilならデフォルトの得点の配分を使う
+	ScoringProfile *checker.ScoringProfile
+
+	// checker.SetImageVerifyModeを参照
+	ImageVerify        string
+	ImageHashThreshold int
+
+	// LoadModelClosedかLoadModelOpen。OpenLoopはLoadModelOpenのときだけ使う
+	LoadModel string
+	OpenLoop  OpenLoopConfig
+
+	// 一時的な失敗のときに再試行する設定。nilなら再試行しない
+	Retry *checker.RetryPolicy
+
+	// 仮想ユーザーごとのキャッシュの容量と、以前のキャッシュを持った状態で始める仮想ユーザーの割合
+	CacheSize      int64
+	ReturningUsers float64
+	// 空でなければ、以前のキャッシュをこのファイルから読み込み、ベンチマーク後に保存する
+	CacheFile string
+
+	// 負荷走行中の様子を表示する先。nilなら表示しない
+	Dashboard io.Writer
+	// 完了したアクションをJSON Linesで書き出す先。nilなら書き出さない
+	Events io.Writer
+	// 空でなければ、失敗したリクエストとレスポンスをこのディレクトリに保存する
+	ArtifactsDir string
+	// リクエストを送れなかったときなど、主催者に調べてもらうためのエラーの出力先。nilなら出力しない
+	ErrorOutput io.Writer
+
+	// trueならResult.Messagesで同じメッセージをまとめない
+	Debug bool
+
+	// 負荷走行中にデフォルトのシナリオと一緒に実行するシナリオ
+	Scenarios []Scenario
+	// trueならデフォルトのシナリオを実行せず、Scenariosだけを実行する
+	// 最初のチェックとベンチマーク後の確認はこの設定に関わらず実行する
+	SkipDefaultScenarios bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BenchmarkTimeout: BenchmarkTimeout,
+		WaitAfterTimeout: WaitAfterTimeout,
+		DrainTimeout:     DrainTimeout,
+		FreshnessWindow:  FreshnessWindow,
+
+		AuditSamples: AuditSamples,
+
+		Rules: DisqualifyRules{
+			MaxFailRatio: float64(FailThreshold) / 100,
+			MinSuccess:   MinSuccess,
+		},
+
+		ImageVerify:        checker.ImageVerifyExact,
+		ImageHashThreshold: checker.DefaultImageHashThreshold,
+
+		LoadModel: LoadModelClosed,
+		OpenLoop: OpenLoopConfig{
+			Rate:        DefaultArrivalRate,
+			Arrival:     ArrivalConstant,
+			MaxInflight: DefaultMaxInflight,
+		},
+
+		CacheSize: cache.DefaultMaxBytes,
+	}
+}
+
+// 負荷走行中に繰り返し実行するシナリオ
+type Scenario struct {
+	// ActionRecordやイベントに記録するシナリオ名
+	Name string
+	// closed-loopでの並列数で、open-loopでは到着率の重みになる
+	Concurrency int
+	// 1回分の処理。シナリオ名と新しいtrace IDを持つctxで呼ばれる
+	// 失敗はchecker.Sessionで記録するので返さない。ctxがキャンセルされたら新しいリクエストを送らずに返す
+	Run func(ctx context.Context, d *Userdata)
+}
+
+// userdataディレクトリから読み込んだもの
+type Userdata struct {
+	Users     []User
+	Admins    []User
+	Sentences []string
+	Images    []*checker.Asset
+}
+
+type User struct {
+	AccountName string
+	Password    string
+	Tags        []string
+}
+
+func (d *Userdata) RandomUser() User {
+	return d.Users[util.RandomNumber(len(d.Users))]
+}
+
+func (d *Userdata) RandomAdmin() User {
+	return d.Admins[util.RandomNumber(len(d.Admins))]
+}
+
+func (d *Userdata) RandomImage() *checker.Asset {
+	return d.Images[util.RandomNumber(len(d.Images))]
+}
+
+func (d *Userdata) RandomSentence() string {
+	return d.Sentences[util.RandomNumber(len(d.Sentences))]
+}
+
+type Result struct {
+	Pass     bool     `json:"pass"`
+	Score    int64    `json:"score"`
+	Suceess  int64    `json:"success"`
+	Fail     int64    `json:"fail"`
+	Messages []string `json:"messages"`
+
+	// 失敗ごとの件数とリクエストID
+	Failures []FailureOutput `json:"failures,omitempty"`
+
+	// 採点に使った得点の配分のハッシュ
+	ScoringProfile string `json:"scoring_profile"`
+
+	// 再試行した場合はその内訳。再試行は成功や失敗の数に含めない
+	Retries *score.RetryStats `json:"retries,omitempty"`
+
+	// 静的ファイルや画像でのブラウザのキャッシュのヒット、ミス、再検証の数
+	Cache *score.CacheStats `json:"cache,omitempty"`
+
+	// エンドポイントごとの圧縮前後の転送量
+	Transfer []TransferOutput `json:"transfer,omitempty"`
+	// エンドポイントごとのDNS、接続、TLS、最初の1バイトまで、ボディの転送にかかった時間
+	Timings []TimingOutput `json:"timings,omitempty"`
+
+	// Config.Eventsへの書き出しが追いつかずに捨てたイベントの数
+	EventsDropped int64 `json:"events_dropped,omitempty"`
+
+	// 失格になった場合はその理由
+	Disqualified *DisqualifiedOutput `json:"disqualified,omitempty"`
+
+	// 中断した場合はtrue
+	Partial bool `json:"partial,omitempty"`
+
+	Load   *LoadOutput   `json:"load,omitempty"`
+	Warmup *WarmupOutput `json:"warmup,omitempty"`
+}
+
+// ウォームアップ中の結果。scoreには含まれない
+type WarmupOutput struct {
+	Duration string   `json:"duration"`
+	Score    int64    `json:"score"`
+	Suceess  int64    `json:"success"`
+	Fail     int64    `json:"fail"`
+	Messages []string `json:"messages"`
+
+	Failures []FailureOutput `json:"failures,omitempty"`
+}
+
+func (r Result) JSON() string {
+	b, _ := json.Marshal(r)
+
+	return string(b)
+}
+
+// checkerの設定はパッケージで1つなので、同時に複数のベンチマークを実行しない
+var runMu sync.Mutex
+
+// webappをベンチマークする
+// ctxがキャンセルされたら新しいリクエストを送るのをやめ、途中までの結果をPartialにして返す
+// 設定やuserdataに問題があって始められなかったときは、主催者に連絡してもらうメッセージを含むResultとエラーを返す
+// webappの問題はエラーにせず、Result.Passで返す
+// 同時に呼ばれたときは前のベンチマークが終わるのを待つ
+func Run(ctx context.Context, c Config) (Result, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	// 前のベンチマークの設定を引き継がない
+	// 結果と作成したものは実行ごとに作り、中断後に残ったシナリオの結果が次の実行に混ざらないようにする
+	checker.Reset()
+	rec := score.NewRecorder()
+	st := newRunState()
+	st.freshnessWindow = c.FreshnessWindow
+	ctx = score.NewContext(withRunState(ctx, st), rec)
+
+	errorOutput := c.ErrorOutput
+	if errorOutput == nil {
+		errorOutput = io.Discard
+	}
+	checker.SetErrorOutput(errorOutput)
+
+	err := checker.SetImageVerifyMode(c.ImageVerify, c.ImageHashThreshold)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	if c.ScoringProfile != nil {
+		checker.SetScoringProfile(c.ScoringProfile)
+	}
+
+	if c.Retry != nil {
+		if c.Retry.MaxRetries < 0 {
+			return needToContactUs(fmt.Errorf("retries should not be negative, got %d", c.Retry.MaxRetries))
+		}
+		checker.SetDefaultRetryPolicy(c.Retry)
+	}
+
+	if c.CacheSize < 0 || c.ReturningUsers < 0 || c.ReturningUsers > 1 {
+		return needToContactUs(fmt.Errorf("cache-size should not be negative and returning-users should be between 0 and 1, got %d and %g", c.CacheSize, c.ReturningUsers))
+	}
+	var persistedCache *cache.Store
+	if c.CacheFile != "" {
+		persistedCache, err = cache.LoadStore(c.CacheFile, c.CacheSize)
+		if err != nil {
+			return needToContactUs(err)
+		}
+	} else if c.ReturningUsers > 0 {
+		persistedCache = cache.NewStore(c.CacheSize)
+	}
+	checker.SetBrowserCache(c.CacheSize, c.ReturningUsers, persistedCache)
+
+	err = validateDisqualifyRules(c.Rules)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	err = validateOpenLoopConfig(c.LoadModel, c.OpenLoop)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	err = validateScenarios(c.Scenarios, c.SkipDefaultScenarios)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	targetHost, err := checker.SetTargetHost(c.Target)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	if c.ArtifactsDir != "" {
+		err := checker.SetArtifactsDir(c.ArtifactsDir)
+		if err != nil {
+			return needToContactUs(err)
+		}
+		defer func() {
+			err := checker.WriteArtifactsIndex()
+			if err != nil {
+				fmt.Fprintln(errorOutput, err)
+			}
+		}()
+	}
+
+	transfer := newTransferStats()
+	checker.AddObserver(transfer.observe)
+	timings := newTimingStats()
+	checker.AddObserver(timings.observe)
+
+	var events *eventWriter
+	if c.Events != nil {
+		events = newEventWriter(c.Events)
+		checker.AddObserver(events.observe)
+		defer events.close()
+	}
+
+	// userdataを読み込めずに返したときも、初期化リクエストのgoroutineが終われるようにする
+	initialize := make(chan bool, 1)
+
+	setupInitialize(ctx, targetHost, initialize)
+
+	users, _, adminUsers, sentences, images, err := prepareUserdata(c.Userdata)
+	if err != nil {
+		return needToContactUs(err)
+	}
+	d := &Userdata{
+		Users:     users,
+		Admins:    adminUsers,
+		Sentences: sentences,
+		Images:    images,
+	}
+	if len(d.Users) == 0 || len(d.Admins) == 0 || len(d.Sentences) == 0 || len(d.Images) == 0 {
+		return needToContactUs(errors.New("userdataにユーザー、管理者ユーザー、文章、画像のいずれかがありません"))
+	}
+
+	st.assetDigests, err = loadAssetManifest(c.Userdata)
+	if err != nil {
+		return needToContactUs(err)
+	}
+
+	initReq := <-initialize
+
+	if ctx.Err() != nil {
+		result := newResult(rec, false, []string{"ベンチマークが中断されました"})
+		result.Partial = true
+		return result, nil
+	}
+
+	if !initReq {
+		return newResult(rec, false, []string{"初期化リクエストに失敗しました"}), nil
+	}
+
+	// 最初にDOMチェックなどをやってしまい、通らなければさっさと失敗させる
+	initialCtx := checker.WithScenario(ctx, "initialCheck")
+	commentScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser(), d.RandomUser().AccountName, d.RandomSentence())
+	postImageScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser(), d.RandomImage(), d.RandomSentence())
+	escapeScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser(), d.RandomImage(), d.RandomSentence())
+	cannotLoginNonexistentUserScenario(checker.NewTraceContext(initialCtx), checker.NewSession())
+	cannotLoginWrongPasswordScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser())
+	cannotAccessAdminScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser())
+	cannotPostWrongCSRFTokenScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser(), d.RandomImage())
+	loginScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), d.RandomUser())
+	banScenario(checker.NewTraceContext(initialCtx), checker.NewSession(), checker.NewSession(), d.RandomUser(), d.RandomAdmin(), d.RandomImage(), d.RandomSentence())
+
+	if ctx.Err() != nil {
+		result := newResult(rec, false, append(rec.FailErrors().StringSlice(), "ベンチマークが中断されました"))
+		result.Partial = true
+		return result, nil
+	}
+
+	if rec.Score().GetFails() > 0 {
+		return newResult(rec, false, rec.FailErrors().StringSlice()), nil
+	}
+
+	scenarios := c.Scenarios
+	if !c.SkipDefaultScenarios {
+		scenarios = append(defaultScenarios(), scenarios...)
+	}
+	workers := make([]scenarioWorker, 0, len(scenarios))
+	for _, sc := range scenarios {
+		run := sc.Run
+		workers = append(workers, scenarioWorker{sc.Name, sc.Concurrency, func(ctx context.Context) {
+			run(ctx, d)
+		}})
+	}
+
+	stopDashboard := func() {}
+	if c.Dashboard != nil {
+		dashboard := newDashboard(c.Dashboard, rec)
+		checker.AddObserver(dashboard.observe)
+		stopDashboard = dashboard.run()
+	}
+
+	// ウォームアップ中もシナリオは止めずに続けて実行し、結果の記録先だけを切り替える
+	if c.Warmup > 0 {
+		rec.StartWarmup()
+		warmupTimer := time.AfterFunc(c.Warmup, rec.EndWarmup)
+		defer warmupTimer.Stop()
+	}
+
+	// 返す前に実行中のシナリオを止め、次のベンチマークの結果に混ざらないようにする
+	// 中断したときはすでに待っているので、DrainTimeoutを2回待たない
+	loadCtx, stopLoad := context.WithCancel(ctx)
+	var running sync.WaitGroup
+	drained := false
+	defer func() {
+		stopLoad()
+		if !drained {
+			waitWithTimeout(&running, c.DrainTimeout)
+		}
+	}()
+
+	var loadOutput *LoadOutput
+	if c.LoadModel == LoadModelOpen {
+		loadOutput = runOpenLoop(loadCtx, workers, c.OpenLoop, c.Warmup+c.BenchmarkTimeout, &running)
+	} else {
+		runClosedLoop(loadCtx, workers, c.Warmup+c.BenchmarkTimeout, &running)
+	}
+
+	select {
+	case <-time.After(c.WaitAfterTimeout):
+	case <-ctx.Done():
+	}
+
+	// 中断したときは実行中のリクエストのレスポンスをDrainTimeoutまで待つ
+	partial := ctx.Err() != nil
+	if partial {
+		waitWithTimeout(&running, c.DrainTimeout)
+		drained = true
+	} else if c.AuditSamples > 0 {
+		auditScenario(checker.NewTraceContext(checker.WithScenario(ctx, "audit")), checker.NewSession(), c.AuditSamples)
+	}
+
+	stopDashboard()
+	if events != nil {
+		events.close()
+	}
+
+	var msgs []string
+	if !c.Debug {
+		msgs = rec.FailErrors().StringSlice()
+	} else {
+		msgs = rec.FailErrors().RawStringSlice()
+	}
+
+	var warmupOutput *WarmupOutput
+	if c.Warmup > 0 {
+		warmupOutput = &WarmupOutput{
+			Duration: c.Warmup.String(),
+			Score:    rec.Warmup().GetScore(),
+			Suceess:  rec.Warmup().GetSucesses(),
+			Fail:     rec.Warmup().GetFails(),
+			Messages: rec.WarmupFailErrors().StringSlice(),
+			Failures: newFailureOutputs(rec.WarmupFailErrors().RawErrors()),
+		}
+	}
+
+	result := newResult(rec, true, msgs)
+	result.Partial = partial
+	result.Load = loadOutput
+	result.Warmup = warmupOutput
+
+	if retryStats := rec.Score().GetRetryStats(); retryStats.Retries > 0 {
+		result.Retries = &retryStats
+	}
+
+	if cacheStats := rec.Score().GetCacheStats(); cacheStats != (score.CacheStats{}) {
+		result.Cache = &cacheStats
+	}
+
+	result.Transfer = transfer.outputs()
+	result.Timings = timings.outputs()
+
+	if events != nil {
+		result.EventsDropped = events.droppedEvents()
+	}
+
+	if c.CacheFile != "" {
+		err := persistedCache.Save(c.CacheFile)
+		if err != nil {
+			fmt.Fprintln(errorOutput, err)
+		}
+	}
+
+	result.Disqualified = c.Rules.check(rec.Score())
+	if result.Disqualified != nil {
+		result.Pass = false
+		result.Messages = append([]string{result.Disqualified.Message}, result.Messages...)
+	}
+
+	return result, nil
+}
+
+func defaultScenarios() []Scenario {
+	return []Scenario{
+		{"indexMoreAndMore", 2, func(ctx context.Context, d *Userdata) {
+			indexMoreAndMoreScenario(ctx, checker.NewSession())
+		}},
+		{"loadIndex", 2, func(ctx context.Context, d *Userdata) {
+			loadIndexScenario(ctx, checker.NewSession())
+		}},
+		{"userAndPostPage", 2, func(ctx context.Context, d *Userdata) {
+			userAndPostPageScenario(ctx, checker.NewSession(), d.RandomUser().AccountName)
+		}},
+		{"comment", 1, func(ctx context.Context, d *Userdata) {
+			commentScenario(ctx, checker.NewSession(), d.RandomUser(), d.RandomUser().AccountName, d.RandomSentence())
+		}},
+		{"postImage", 1, func(ctx context.Context, d *Userdata) {
+			postImageScenario(ctx, checker.NewSession(), d.RandomUser(), d.RandomImage(), d.RandomSentence())
+			cannotPostWrongCSRFTokenScenario(ctx, checker.NewSession(), d.RandomUser(), d.RandomImage())
+		}},
+		{"escape", 1, func(ctx context.Context, d *Userdata) {
+			escapeScenario(ctx, checker.NewSession(), d.RandomUser(), d.RandomImage(), d.RandomSentence())
+		}},
+		{"login", 2, func(ctx context.Context, d *Userdata) {
+			loginScenario(ctx, checker.NewSession(), d.RandomUser())
+			cannotLoginNonexistentUserScenario(ctx, checker.NewSession())
+			cannotLoginWrongPasswordScenario(ctx, checker.NewSession(), d.RandomUser())
+		}},
+		{"ban", 1, func(ctx context.Context, d *Userdata) {
+			banScenario(ctx, checker.NewSession(), checker.NewSession(), d.RandomUser(), d.RandomAdmin(), d.RandomImage(), d.RandomSentence())
+			cannotAccessAdminScenario(ctx, checker.NewSession(), d.RandomUser())
+		}},
+	}
+}
+
+func validateScenarios(scenarios []Scenario, skipDefault bool) error {
+	if skipDefault && len(scenarios) == 0 {
+		return errors.New("no scenarios to run")
+	}
+
+	names := map[string]bool{}
+	if !skipDefault {
+		for _, sc := range defaultScenarios() {
+			names[sc.Name] = true
+		}
+	}
+
+	for _, sc := range scenarios {
+		if sc.Name == "" {
+			return errors.New("scenario name should not be empty")
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("duplicate scenario name: %s", sc.Name)
+		}
+		names[sc.Name] = true
+
+		if sc.Concurrency <= 0 {
+			return fmt.Errorf("concurrency of scenario %s should be positive, got %d", sc.Name, sc.Concurrency)
+		}
+		if sc.Run == nil {
+			return fmt.Errorf("scenario %s has no Run", sc.Name)
+		}
+	}
+	return nil
+}
+
+func newResult(rec *score.Recorder, pass bool, messages []string) Result {
+	return Result{
+		Pass:     pass,
+		Score:    rec.Score().GetScore(),
+		Suceess:  rec.Score().GetSucesses(),
+		Fail:     rec.Score().GetFails(),
+		Messages: messages,
+		Failures: newFailureOutputs(rec.FailErrors().RawErrors()),
+
+		ScoringProfile: checker.GetScoringProfile().Hash(),
+	}
+}
+
+// 主催者に連絡して欲しいエラーの結果
+func NeedToContactUs(message string) Result {
+	return newResult(score.NewRecorder(), false, []string{"！！！主催者に連絡してください！！！", message})
+}
+
+func needToContactUs(err error) (Result, error) {
+	return NeedToContactUs(err.Error()), err
+}
+
+// wgを待つが、timeoutを過ぎたら待つのをやめる
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func setupInitialize(ctx context.Context, targetHost *url.URL, initialize chan bool) {
+	go func(targetHost *url.URL) {
+		client := &http.Client{
+			Timeout: InitializeTimeout,
+		}
+
+		parsedURL := &url.URL{
+			Scheme: targetHost.Scheme,
+			Host:   targetHost.Host,
+			Path:   "/initialize",
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", parsedURL.String(), nil)
+		if err != nil {
+			return
+		}
+
+		req.Header.Set("User-Agent", checker.UserAgent)
+
+		res, err := client.Do(req)
+
+		if err != nil {
+			initialize <- false
+			return
+		}
+		defer res.Body.Close()
+		initialize <- true
+	}(targetHost)
+}
@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeUserdata(t *testing.T) string {
+	dir := t.TempDir()
+	names := []string{}
+	for i := 1; i <= 20; i++ {
+		names = append(names, fmt.Sprintf("user%d", i))
+	}
+	files := map[string]string{
+		"names.txt":     strings.Join(names, "\n"),
+		"kaomoji.txt":   "(´・ω・`)\n",
+		"img/00001.jpg": "jpeg",
+	}
+	err := os.Mkdir(filepath.Join(dir, "img"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		err = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRun_invalidConfig(t *testing.T) {
+	noop := func(ctx context.Context, d *Userdata) {}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"no target", func(c *Config) { c.Target = "" }},
+		{"no userdata", func(c *Config) { c.Userdata = "" }},
+		{"unknown load model", func(c *Config) { c.LoadModel = "unknown" }},
+		{"no scenarios", func(c *Config) { c.SkipDefaultScenarios = true }},
+		{"duplicate scenario", func(c *Config) { c.Scenarios = []Scenario{{"login", 1, noop}} }},
+		{"no concurrency", func(c *Config) { c.Scenarios = []Scenario{{"custom", 0, noop}} }},
+	}
+
+	for _, tt := range tests {
+		c := DefaultConfig()
+		c.Target = "http://localhost:1"
+		c.Userdata = writeUserdata(t)
+		tt.modify(&c)
+
+		result, err := Run(context.Background(), c)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+		if result.Pass || len(result.Messages) != 2 || result.Messages[1] != err.Error() {
+			t.Errorf("%s: unexpected result: %+v", tt.name, result)
+		}
+	}
+}
+
+func TestRun_repeated(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/initialize" {
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	c := DefaultConfig()
+	c.Target = ts.URL
+	c.Userdata = writeUserdata(t)
+
+	first, err := Run(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pass || first.Fail == 0 {
+		t.Fatalf("expected the initial check to fail: %+v", first)
+	}
+
+	// 前の実行の失敗を引き継がない
+	second, err := Run(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Fail != first.Fail || len(second.Messages) != len(first.Messages) {
+		t.Errorf("expected the same result, got %+v and %+v", first, second)
+	}
+}
+
+func TestRun_cancelled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 初期化の途中で中断する
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	c := DefaultConfig()
+	c.Target = ts.URL
+	c.Userdata = writeUserdata(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	result, err := Run(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("should return soon after cancelled, took %s", elapsed)
+	}
+	if !result.Partial || result.Pass {
+		t.Errorf("expected partial result: %+v", result)
+	}
+}
@@ -0,0 +1,198 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+const (
+	DashboardInterval    = time.Second
+	dashboardTopFailures = 3
+)
+
+// 負荷走行中の様子をDashboardIntervalごとに表示する
+// 出力先が端末なら表示を書き換え、そうでなければ1行ずつ出力する
+type dashboard struct {
+	w   io.Writer
+	tty bool
+	rec *score.Recorder
+
+	mu       sync.Mutex
+	requests map[string]int64
+	prev     map[string]int64
+	total    int64
+	errors   int64
+	// 前回表示してからのレイテンシ。表示するたびに捨てる
+	latencies *util.Durations
+
+	start      time.Time
+	lastRender time.Time
+	lines      int
+}
+
+func newDashboard(w io.Writer, rec *score.Recorder) *dashboard {
+	return &dashboard{
+		w:         w,
+		tty:       isTerminal(w),
+		rec:       rec,
+		requests:  map[string]int64{},
+		prev:      map[string]int64{},
+		latencies: &util.Durations{},
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (d *dashboard) observe(r checker.ActionRecord) {
+	name := r.Scenario
+	if name == "" {
+		name = "-"
+	}
+
+	d.mu.Lock()
+	d.latencies.Add(r.Latency)
+	d.requests[name]++
+	d.total++
+	if r.Category != "" {
+		d.errors++
+	}
+	d.mu.Unlock()
+}
+
+// 表示を始める。返り値の関数を呼ぶと最後にもう一度表示して止まる
+func (d *dashboard) run() func() {
+	d.start = time.Now()
+	d.lastRender = d.start
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(DashboardInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.render()
+			case <-done:
+				d.render()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (d *dashboard) render() {
+	now := time.Now()
+
+	d.mu.Lock()
+	interval := now.Sub(d.lastRender).Seconds()
+	d.lastRender = now
+
+	names := make([]string, 0, len(d.requests))
+	rps := map[string]float64{}
+	for name, n := range d.requests {
+		names = append(names, name)
+		if interval > 0 {
+			rps[name] = float64(n-d.prev[name]) / interval
+		}
+		d.prev[name] = n
+	}
+	total, errors := d.total, d.errors
+	latencies := d.latencies
+	d.latencies = &util.Durations{}
+	d.mu.Unlock()
+
+	sort.Strings(names)
+
+	errorRate := 0.0
+	if total > 0 {
+		errorRate = float64(errors) / float64(total) * 100
+	}
+
+	label := "score"
+	if d.rec.IsWarmingUp() {
+		label = "warmup score"
+	}
+
+	latency := latencies.Summary()
+	failures := topFailures(d.rec.CurrentFailErrors().RawErrors(), dashboardTopFailures)
+	elapsed := now.Sub(d.start).Truncate(time.Second)
+
+	if !d.tty {
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "[%s] %s=%d requests=%d errors=%d(%.2f%%) p50=%.1fms p90=%.1fms p99=%.1fms",
+			elapsed, strings.ReplaceAll(label, " ", "_"), d.rec.Current().GetScore(), total, errors, errorRate, latency.P50, latency.P90, latency.P99)
+		for _, name := range names {
+			fmt.Fprintf(b, " %s=%.1f/s", name, rps[name])
+		}
+		for _, f := range failures {
+			fmt.Fprintf(b, " fail=%q(%d)", f.Message, f.Count)
+		}
+		fmt.Fprintln(d.w, b.String())
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("elapsed %s  %s %d  requests %d  errors %d (%.2f%%)",
+			elapsed, label, d.rec.Current().GetScore(), total, errors, errorRate),
+		fmt.Sprintf("latency p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms",
+			latency.P50, latency.P90, latency.P99, latency.Max),
+		"",
+		fmt.Sprintf("%-20s %10s", "scenario", "req/s"),
+	}
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%-20s %10.1f", name, rps[name]))
+	}
+	if len(failures) > 0 {
+		lines = append(lines, "", "top failures")
+		for _, f := range failures {
+			lines = append(lines, fmt.Sprintf("%6d  %s", f.Count, f.Message))
+		}
+	}
+
+	// 前回表示した行まで戻って消してから書き直す
+	if d.lines > 0 {
+		fmt.Fprintf(d.w, "\033[%dA\033[J", d.lines)
+	}
+	fmt.Fprintln(d.w, strings.Join(lines, "\n"))
+	d.lines = len(lines)
+}
+
+// 件数の多い順にn件の失敗
+func topFailures(errs []error, n int) []FailureOutput {
+	failures := newFailureOutputs(errs)
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].Count > failures[j].Count
+	})
+	if len(failures) > n {
+		failures = failures[:n]
+	}
+	return failures
+}
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func TestDashboard_lineMode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	rec := score.NewRecorder()
+	rec.Score().SetScore(5)
+	d := newDashboard(buf, rec)
+	if d.tty {
+		t.Fatal("bytes.Buffer should not be a terminal")
+	}
+
+	d.observe(checker.ActionRecord{Scenario: "login", Latency: 10 * time.Millisecond})
+	d.observe(checker.ActionRecord{Scenario: "login", Latency: 30 * time.Millisecond, Category: checker.FailError})
+	d.observe(checker.ActionRecord{Latency: 20 * time.Millisecond})
+
+	// 1秒経ったときの表示にする
+	d.start = time.Now().Add(-time.Second)
+	d.lastRender = d.start
+	d.render()
+
+	// 2回目はその間のリクエストがないので0になる
+	d.render()
+
+	out := buf.String()
+	if strings.Contains(out, "\033[") {
+		t.Errorf("line mode should not contain escape sequences: %q", out)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", out)
+	}
+	for _, expected := range []string{"[1s] ", " score=5 ", " requests=3 errors=1(33.33%)", " p50=20.0ms", " -=1.0/s", " login=2.0/s"} {
+		if !strings.Contains(lines[0], expected) {
+			t.Errorf("expected %q in %q", expected, lines[0])
+		}
+	}
+	if !strings.Contains(lines[1], " p50=0.0ms") {
+		t.Errorf("expected latency only since last line: %q", lines[1])
+	}
+	if !strings.Contains(lines[1], " login=0.0/s") {
+		t.Errorf("expected no requests since last line: %q", lines[1])
+	}
+}
@@ -0,0 +1,44 @@
+package bench
+
+import (
+	"strings"
+)
+
+// 集計のために、IDやアカウント名を含むパスを*にまとめたエンドポイント名を返す
+// パターンは-scoring-profileのendpointsと同じ書き方になる
+//
+//	GET /posts/123         → GET /posts/*
+//	GET /@mary             → GET /@*
+//	GET /image/1.jpg       → GET /image/*.jpg
+//	GET /posts?max_created_at=... → GET /posts
+func endpointOf(method, p string) string {
+	p, _, _ = strings.Cut(p, "?")
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		name, ext, _ := strings.Cut(seg, ".")
+		switch {
+		case strings.HasPrefix(seg, "@"):
+			segments[i] = "@*"
+		case name != "" && isDigits(name):
+			segments[i] = "*"
+			if ext != "" {
+				segments[i] += "." + ext
+			}
+		}
+	}
+
+	return method + " " + strings.Join(segments, "/")
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,24 @@
+package bench
+
+import "testing"
+
+func TestEndpointOf(t *testing.T) {
+	tests := []struct {
+		method, path, expected string
+	}{
+		{"GET", "/", "GET /"},
+		{"GET", "/posts/123", "GET /posts/*"},
+		{"GET", "/posts?max_created_at=2016-01-02T11%3A46%3A21%2B09%3A00", "GET /posts"},
+		{"GET", "/@mary", "GET /@*"},
+		{"GET", "/image/42.jpg", "GET /image/*.jpg"},
+		{"GET", "/js/main.js", "GET /js/main.js"},
+		{"GET", "js/timeago.min.js", "GET /js/timeago.min.js"},
+		{"POST", "/comment", "POST /comment"},
+	}
+
+	for _, tt := range tests {
+		if got := endpointOf(tt.method, tt.path); got != tt.expected {
+			t.Errorf("%s %s: expected %q, got %q", tt.method, tt.path, tt.expected, got)
+		}
+	}
+}
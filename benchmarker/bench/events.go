@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+)
+
+// Config.Eventsに出力するアクション1回分のイベント
+type Event struct {
+	Time        string       `json:"time"`
+	Scenario    string       `json:"scenario"`
+	Description string       `json:"description"`
+	Method      string       `json:"method"`
+	Path        string       `json:"path"`
+	Status      int          `json:"status"`
+	Duration    float64      `json:"duration_ms"`
+	Timing      *EventTiming `json:"timing,omitempty"`
+	Bytes       int64        `json:"bytes"`
+	Decoded     int64        `json:"decoded_bytes"`
+	Encoding    string       `json:"content_encoding,omitempty"`
+	Retries     int          `json:"retries,omitempty"`
+	Cache       string       `json:"cache,omitempty"`
+	ScoreDelta  int64        `json:"score_delta"`
+	RequestID   string       `json:"request_id,omitempty"`
+	Warmup      bool         `json:"warmup,omitempty"`
+	Category    string       `json:"category,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// duration_msのうち、ネットワークの段階ごとの時間
+type EventTiming struct {
+	DNS            float64 `json:"dns_ms"`
+	Connect        float64 `json:"connect_ms"`
+	TLS            float64 `json:"tls_ms"`
+	TTFB           float64 `json:"ttfb_ms"`
+	Transfer       float64 `json:"transfer_ms"`
+	NewConnections int     `json:"new_connections"`
+}
+
+// 書き出しを待っているイベントの上限。書き出しが追いつかないときはアクションを待たせずに捨てる
+const eventBufferSize = 4096
+
+// 完了したアクションをJSON Linesで書き出す
+// アクションのgoroutineでは書き出さず、1つのgoroutineでまとめて書き出す
+type eventWriter struct {
+	mu      sync.RWMutex
+	closed  bool
+	events  chan Event
+	done    chan struct{}
+	dropped int64
+}
+
+func newEventWriter(w io.Writer) *eventWriter {
+	ew := &eventWriter{
+		events: make(chan Event, eventBufferSize),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(ew.done)
+		enc := json.NewEncoder(w)
+		for e := range ew.events {
+			// 書き込めなくてもベンチマークは続ける
+			_ = enc.Encode(e)
+		}
+	}()
+	return ew
+}
+
+// 溜まっているイベントを書き出してから終わる。2回目以降は何もしない
+// 終わった後に完了したアクションは書き出さない
+func (w *eventWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// 書き出しが追いつかずに捨てたイベントの数
+func (w *eventWriter) droppedEvents() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+func (w *eventWriter) observe(r checker.ActionRecord) {
+	e := Event{
+		Time:        r.Time.Format(time.RFC3339Nano),
+		Scenario:    r.Scenario,
+		Description: r.Description,
+		Method:      r.Method,
+		Path:        r.Path,
+		Status:      r.StatusCode,
+		Duration:    milliseconds(r.Latency),
+		Bytes:       r.Bytes,
+		Decoded:     r.DecodedBytes,
+		Encoding:    r.ContentEncoding,
+		Retries:     r.Retries,
+		Cache:       r.Cache,
+		ScoreDelta:  r.ScoreDelta,
+		RequestID:   r.RequestID,
+		Warmup:      r.Warmup,
+		Category:    string(r.Category),
+		Error:       r.Error,
+	}
+
+	// リクエストを送らなかったときは出力しない
+	if r.Timing != (checker.Timing{}) {
+		e.Timing = &EventTiming{
+			DNS:            milliseconds(r.Timing.DNS),
+			Connect:        milliseconds(r.Timing.Connect),
+			TLS:            milliseconds(r.Timing.TLS),
+			TTFB:           milliseconds(r.Timing.TTFB),
+			Transfer:       milliseconds(r.Timing.Transfer),
+			NewConnections: r.Timing.NewConnections,
+		}
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.events <- e:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+)
+
+// unblockされるまで書き込みを止めるWriter
+type blockingWriter struct {
+	buf     bytes.Buffer
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return w.buf.Write(p)
+}
+
+func TestEventWriter_dropAndFlush(t *testing.T) {
+	bw := &blockingWriter{unblock: make(chan struct{})}
+	w := newEventWriter(bw)
+
+	// 書き出しが止まっていてもアクションを待たせず、溢れた分は捨てる
+	n := eventBufferSize + 10
+	for i := 0; i < n; i++ {
+		w.observe(checker.ActionRecord{Path: "/"})
+	}
+	if d := w.droppedEvents(); d < 9 || d > 10 {
+		t.Errorf("expected about 10 dropped events, got %d", d)
+	}
+
+	// 終わるときに溜まっている分を書き出す
+	close(bw.unblock)
+	w.close()
+	lines := strings.Count(bw.buf.String(), "\n")
+	if int64(lines) != int64(n)-w.droppedEvents() {
+		t.Errorf("expected %d events written, got %d", int64(n)-w.droppedEvents(), lines)
+	}
+
+	// 閉じた後のイベントは書き出さない
+	w.observe(checker.ActionRecord{Path: "/"})
+	w.close()
+	if strings.Count(bw.buf.String(), "\n") != lines {
+		t.Error("events after close should not be written")
+	}
+}
@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+)
+
+// 1つの失敗につき出力するリクエストIDの数
+const MaxRequestIDsPerFailure = 5
+
+// 同じメッセージの失敗をまとめたもの
+// request_idsでwebappのログを検索できる
+type FailureOutput struct {
+	Message    string   `json:"message"`
+	Count      int      `json:"count"`
+	RequestIDs []string `json:"request_ids,omitempty"`
+}
+
+func newFailureOutputs(errs []error) []FailureOutput {
+	failures := []FailureOutput{}
+	index := map[string]int{}
+
+	for _, err := range errs {
+		msg := err.Error()
+		i, ok := index[msg]
+		if !ok {
+			i = len(failures)
+			index[msg] = i
+			failures = append(failures, FailureOutput{Message: msg})
+		}
+		failures[i].Count++
+
+		var reqErr *checker.RequestError
+		if errors.As(err, &reqErr) && reqErr.RequestID != "" && len(failures[i].RequestIDs) < MaxRequestIDsPerFailure {
+			failures[i].RequestIDs = append(failures[i].RequestIDs, reqErr.RequestID)
+		}
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Message < failures[j].Message
+	})
+
+	return failures
+}
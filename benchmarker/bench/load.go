@@ -0,0 +1,207 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+const (
+	LoadModelClosed = "closed"
+	LoadModelOpen   = "open"
+
+	ArrivalConstant = "constant"
+	ArrivalPoisson  = "poisson"
+
+	DefaultArrivalRate = 50
+	DefaultMaxInflight = 100
+)
+
+// 負荷走行中に繰り返し実行するシナリオ
+// concurrencyはclosed-loopでの並列数で、open-loopでは到着率の重みになる
+// 1回の実行ごとに、シナリオ名と新しいtrace IDを持つctxでrunを呼ぶ
+type scenarioWorker struct {
+	name        string
+	concurrency int
+	run         func(ctx context.Context)
+}
+
+// 前の実行が終わったら次を始めるclosed-loopでtimeoutまでシナリオを実行する
+// timeout後やctxがキャンセルされた後は新しいシナリオを始めないが、実行中のシナリオは待たない
+// 実行中のシナリオはrunningで待てる
+func runClosedLoop(ctx context.Context, workers []scenarioWorker, timeout time.Duration, running *sync.WaitGroup) {
+	done := make(chan struct{})
+
+	for _, w := range workers {
+		for i := 0; i < w.concurrency; i++ {
+			running.Add(1)
+			go func(w scenarioWorker) {
+				defer running.Done()
+				for {
+					select {
+					case <-done:
+						return
+					case <-ctx.Done():
+						return
+					default:
+					}
+					w.run(checker.NewTraceContext(checker.WithScenario(ctx, w.name)))
+				}
+			}(w)
+		}
+	}
+
+	select {
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+	close(done)
+}
+
+// open-loopでシナリオを開始する間隔の設定
+type OpenLoopConfig struct {
+	// 1秒あたりに開始するシナリオの数
+	Rate float64
+	// ArrivalConstantかArrivalPoisson
+	Arrival string
+	// 同時に実行するシナリオの上限。0なら上限を設けない
+	MaxInflight int
+}
+
+type LoadOutput struct {
+	Model         string               `json:"model"`
+	Arrival       string               `json:"arrival"`
+	ArrivalRate   float64              `json:"arrival_rate"`
+	Arrivals      int64                `json:"arrivals"`
+	Started       int64                `json:"started"`
+	NotStarted    int64                `json:"not_started"`
+	QueueingDelay util.DurationSummary `json:"queueing_delay"`
+}
+
+func validateOpenLoopConfig(model string, c OpenLoopConfig) error {
+	switch model {
+	case LoadModelClosed:
+		return nil
+	case LoadModelOpen:
+	default:
+		return fmt.Errorf("unknown load model: %s", model)
+	}
+
+	if c.Arrival != ArrivalConstant && c.Arrival != ArrivalPoisson {
+		return fmt.Errorf("unknown arrival process: %s", c.Arrival)
+	}
+	if c.Rate <= 0 {
+		return fmt.Errorf("arrival rate should be positive, got %f", c.Rate)
+	}
+	if c.MaxInflight < 0 {
+		return fmt.Errorf("max inflight should not be negative, got %d", c.MaxInflight)
+	}
+	return nil
+}
+
+// 前の実行の終了を待たず、rate回/秒でシナリオを開始するopen-loopでtimeoutまでシナリオを実行する
+// rateはworkerのconcurrencyの比で分配する
+// 同時に実行するシナリオがmaxInflightに達している間、開始予定のシナリオは待たされ、その時間をqueueing delayとして記録する
+// maxInflightが0なら上限を設けない
+// closed-loopと同様に、実行中のシナリオはrunningで待てる
+func runOpenLoop(ctx context.Context, workers []scenarioWorker, c OpenLoopConfig, timeout time.Duration, running *sync.WaitGroup) *LoadOutput {
+	var (
+		arrivals int64
+		started  int64
+		delays   util.Durations
+		wg       sync.WaitGroup
+	)
+
+	var sem chan struct{}
+	if c.MaxInflight > 0 {
+		sem = make(chan struct{}, c.MaxInflight)
+	}
+
+	total := 0
+	for _, w := range workers {
+		total += w.concurrency
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for i, w := range workers {
+		wg.Add(1)
+		running.Add(1)
+		go func(w scenarioWorker, seed int64) {
+			defer wg.Done()
+			defer running.Done()
+
+			r := rand.New(rand.NewSource(seed))
+			rate := c.Rate * float64(w.concurrency) / float64(total)
+			next := time.Now()
+
+			for {
+				next = next.Add(nextArrival(r, c.Arrival, rate))
+				if !next.Before(deadline) {
+					return
+				}
+				select {
+				case <-time.After(time.Until(next)):
+				case <-ctx.Done():
+					return
+				}
+
+				atomic.AddInt64(&arrivals, 1)
+				running.Add(1)
+				go func(scheduledAt time.Time) {
+					defer running.Done()
+
+					if sem != nil {
+						select {
+						case sem <- struct{}{}:
+						case <-ctx.Done():
+							return
+						}
+						defer func() { <-sem }()
+					}
+
+					if !time.Now().Before(deadline) || ctx.Err() != nil {
+						return
+					}
+
+					delays.Add(time.Since(scheduledAt))
+					atomic.AddInt64(&started, 1)
+					w.run(checker.NewTraceContext(checker.WithScenario(ctx, w.name)))
+				}(next)
+			}
+		}(w, time.Now().UnixNano()+int64(i))
+	}
+
+	wg.Wait()
+	select {
+	case <-time.After(time.Until(deadline)):
+	case <-ctx.Done():
+	}
+
+	a := atomic.LoadInt64(&arrivals)
+	st := atomic.LoadInt64(&started)
+
+	return &LoadOutput{
+		Model:         LoadModelOpen,
+		Arrival:       c.Arrival,
+		ArrivalRate:   c.Rate,
+		Arrivals:      a,
+		Started:       st,
+		NotStarted:    a - st,
+		QueueingDelay: delays.Summary(),
+	}
+}
+
+// 次のシナリオが開始するまでの間隔
+func nextArrival(r *rand.Rand, arrival string, rate float64) time.Duration {
+	if arrival == ArrivalPoisson {
+		return time.Duration(r.ExpFloat64() / rate * float64(time.Second))
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
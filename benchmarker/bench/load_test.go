@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNextArrival(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	if d := nextArrival(r, ArrivalConstant, 4); d != 250*time.Millisecond {
+		t.Errorf("constant: expected 250ms, got %s", d)
+	}
+
+	// 指数分布の平均は1/rate
+	var sum time.Duration
+	const n = 10000
+	for i := 0; i < n; i++ {
+		sum += nextArrival(r, ArrivalPoisson, 4)
+	}
+	if mean := sum / n; mean < 240*time.Millisecond || mean > 260*time.Millisecond {
+		t.Errorf("poisson: expected mean about 250ms, got %s", mean)
+	}
+}
+
+func TestRunOpenLoop_arrivals(t *testing.T) {
+	var heavy, light int64
+	workers := []scenarioWorker{
+		{"heavy", 3, func(ctx context.Context) { atomic.AddInt64(&heavy, 1) }},
+		{"light", 1, func(ctx context.Context) { atomic.AddInt64(&light, 1) }},
+	}
+
+	var running sync.WaitGroup
+	c := OpenLoopConfig{Rate: 40, Arrival: ArrivalConstant}
+	out := runOpenLoop(context.Background(), workers, c, time.Second, &running)
+	running.Wait()
+
+	// 1秒間に30回と10回。最初の1回は間隔の分だけ遅れて始まる
+	if heavy < 27 || heavy > 30 || light < 8 || light > 10 {
+		t.Errorf("unexpected arrivals per worker: heavy %d, light %d", heavy, light)
+	}
+	if out.Arrivals != heavy+light || out.Started != out.Arrivals || out.NotStarted != 0 {
+		t.Errorf("unexpected output: %+v", out)
+	}
+	if out.Model != LoadModelOpen || out.Arrival != ArrivalConstant || out.ArrivalRate != 40 {
+		t.Errorf("unexpected output: %+v", out)
+	}
+}
+
+func TestRunOpenLoop_maxInflight(t *testing.T) {
+	var inflight, maxInflight int64
+	workers := []scenarioWorker{
+		{"slow", 1, func(ctx context.Context) {
+			n := atomic.AddInt64(&inflight, 1)
+			for {
+				m := atomic.LoadInt64(&maxInflight)
+				if n <= m || atomic.CompareAndSwapInt64(&maxInflight, m, n) {
+					break
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+			atomic.AddInt64(&inflight, -1)
+		}},
+	}
+
+	var running sync.WaitGroup
+	c := OpenLoopConfig{Rate: 50, Arrival: ArrivalConstant, MaxInflight: 2}
+	out := runOpenLoop(context.Background(), workers, c, 500*time.Millisecond, &running)
+	running.Wait()
+
+	if maxInflight != 2 {
+		t.Errorf("expected at most 2 running scenarios, got %d", maxInflight)
+	}
+
+	// 到着した分は開始できないまま終わったものとして数える
+	if out.Started < 1 || out.Started > 10 || out.Arrivals < 20 {
+		t.Errorf("unexpected output: %+v", out)
+	}
+	if out.NotStarted != out.Arrivals-out.Started {
+		t.Errorf("not started should be arrivals minus started: %+v", out)
+	}
+
+	// 待たされた時間がqueueing delayになる
+	if out.QueueingDelay.Count != out.Started || out.QueueingDelay.Max < 50 {
+		t.Errorf("unexpected queueing delay: %+v", out.QueueingDelay)
+	}
+}
@@ -0,0 +1,85 @@
+package bench
+
+import (
+	"fmt"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+const (
+	RuleMaxFails     = "max-fails"
+	RuleMaxFailRatio = "max-fail-ratio"
+	RuleCritical     = "critical"
+	RuleMinSuccess   = "min-success"
+)
+
+// 失格にする条件
+// MaxFailsとMinSuccessは0なら判定しない
+type DisqualifyRules struct {
+	MaxFails     int64
+	MaxFailRatio float64
+	// trueならユーザーのデータの漏洩やCSRFのすり抜けなどの致命的な失敗でも失格にしない
+	AllowCritical bool
+	MinSuccess    int64
+}
+
+// 失格になった理由
+type DisqualifiedOutput struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func validateDisqualifyRules(r DisqualifyRules) error {
+	if r.MaxFails < 0 {
+		return fmt.Errorf("max fails should not be negative, got %d", r.MaxFails)
+	}
+	if r.MaxFailRatio < 0 || r.MaxFailRatio > 1 {
+		return fmt.Errorf("max fail ratio should be between 0 and 1, got %f", r.MaxFailRatio)
+	}
+	if r.MinSuccess < 0 {
+		return fmt.Errorf("min success should not be negative, got %d", r.MinSuccess)
+	}
+	return nil
+}
+
+// 結果が失格の条件に当てはまるか判定する。当てはまらなければnil
+// 複数の条件に当てはまるときは、致命的な失敗、失敗数、失敗の割合、成功数の順に最初のものを返す
+func (r DisqualifyRules) check(s *score.Score) *DisqualifiedOutput {
+	sucesses := s.GetSucesses()
+	fails := s.GetFails()
+
+	if !r.AllowCritical {
+		if criticalFails := s.GetCriticalFails(); criticalFails > 0 {
+			return &DisqualifiedOutput{
+				Rule:    RuleCritical,
+				Message: fmt.Sprintf("致命的なエラーが%d件発生しました", criticalFails),
+			}
+		}
+	}
+
+	if r.MaxFails > 0 && fails > r.MaxFails {
+		return &DisqualifiedOutput{
+			Rule:    RuleMaxFails,
+			Message: fmt.Sprintf("エラーが%d件を超えました (%d件)", r.MaxFails, fails),
+		}
+	}
+
+	if total := sucesses + fails; total > 0 {
+		ratio := float64(fails) / float64(total)
+		if ratio > r.MaxFailRatio {
+			return &DisqualifiedOutput{
+				Rule:    RuleMaxFailRatio,
+				Message: fmt.Sprintf("エラーの割合が%.1f%%を超えました (%.1f%%)", r.MaxFailRatio*100, ratio*100),
+			}
+		}
+	}
+
+	if r.MinSuccess > 0 && sucesses < r.MinSuccess {
+		return &DisqualifiedOutput{
+			Rule:    RuleMinSuccess,
+			Message: fmt.Sprintf("成功したリクエストが%d件に達しませんでした (%d件)", r.MinSuccess, sucesses),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func newScore(sucesses, fails, criticalFails int) *score.Score {
+	s := &score.Score{}
+	for i := 0; i < sucesses; i++ {
+		s.SetScore(1)
+	}
+	for i := 0; i < fails; i++ {
+		s.SetFails(10)
+	}
+	for i := 0; i < criticalFails; i++ {
+		s.SetCriticalFails(10)
+	}
+	return s
+}
+
+func TestDisqualifyRules_check(t *testing.T) {
+	rules := DisqualifyRules{
+		MaxFails:     10,
+		MaxFailRatio: 0.05,
+		MinSuccess:   100,
+	}
+
+	tests := []struct {
+		name                           string
+		sucesses, fails, criticalFails int
+		allowCritical                  bool
+		expected                       string
+	}{
+		{"pass", 1000, 5, 0, false, ""},
+		{"critical", 1000, 0, 1, false, RuleCritical},
+		{"allow critical", 1000, 0, 1, true, ""},
+		{"max fails", 1000, 11, 0, false, RuleMaxFails},
+		{"max fail ratio", 100, 10, 0, false, RuleMaxFailRatio},
+		{"min success", 99, 0, 0, false, RuleMinSuccess},
+	}
+
+	for _, tt := range tests {
+		r := rules
+		r.AllowCritical = tt.allowCritical
+		d := r.check(newScore(tt.sucesses, tt.fails, tt.criticalFails))
+
+		if tt.expected == "" {
+			if d != nil {
+				t.Errorf("%s: expected pass, got %s", tt.name, d.Rule)
+			}
+			continue
+		}
+		if d == nil {
+			t.Errorf("%s: expected %s, got pass", tt.name, tt.expected)
+		} else if d.Rule != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, d.Rule)
+		}
+	}
+}
+
+func TestDefaultConfig_rules(t *testing.T) {
+	rules := DefaultConfig().Rules
+
+	// 成功があっても、失敗の割合がFailThreshold%を超えたら失格
+	if d := rules.check(newScore(1000, 1000, 0)); d == nil || d.Rule != RuleMaxFailRatio {
+		t.Errorf("expected %s, got %+v", RuleMaxFailRatio, d)
+	}
+	if d := rules.check(newScore(1000, 10, 0)); d != nil {
+		t.Errorf("expected pass, got %s", d.Rule)
+	}
+}
+
+func TestValidateDisqualifyRules(t *testing.T) {
+	for _, r := range []DisqualifyRules{
+		{MaxFails: -1},
+		{MaxFailRatio: 1.5},
+		{MaxFailRatio: -0.1},
+		{MinSuccess: -1},
+	} {
+		if err := validateDisqualifyRules(r); err == nil {
+			t.Errorf("expected error for %+v", r)
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func checkHTML(f func(*goquery.Document) error) func(io.Reader) error {
+	return func(r io.Reader) error {
+		doc, err := goquery.NewDocumentFromReader(r)
+		if err != nil {
+			return errors.New("ページのHTMLがパースできませんでした")
+		}
+		return f(doc)
+	}
+}
+
+// 1ページに表示される画像にリクエストする
+// TODO: 画像には並列リクエストするべきでは？
+func loadImages(ctx context.Context, s *checker.Session, imageURLs []string) {
+	for _, url := range imageURLs {
+		// ベンチマーク中に投稿した画像なら、投稿した画像と一致するか確認する
+		asset := runStateFromContext(ctx).created.ImageAsset(url)
+		if asset == nil {
+			asset = &checker.Asset{}
+		}
+		imgReq := checker.NewImageAction(url, asset)
+		imgReq.Description = "投稿画像を読み込めること"
+		imgReq.Play(ctx, s)
+	}
+}
+
+func extractImages(doc *goquery.Document) []string {
+	imageURLs := []string{}
+
+	doc.Find("img.isu-image").Each(func(_ int, selection *goquery.Selection) {
+		if url, ok := selection.Attr("src"); ok {
+			imageURLs = append(imageURLs, url)
+		}
+	}).Length()
+
+	return imageURLs
+}
+
+func extractPostLinks(doc *goquery.Document) []string {
+	postLinks := []string{}
+
+	doc.Find("a.isu-post-permalink").Each(func(_ int, selection *goquery.Selection) {
+		if url, ok := selection.Attr("href"); ok {
+			postLinks = append(postLinks, url)
+		}
+	}).Length()
+
+	return postLinks
+}
+
+// インデックスにリクエストして「もっと見る」を最大10ページ辿る
+// WaitAfterTimeout秒たったら問答無用で打ち切る
+func indexMoreAndMoreScenario(ctx context.Context, s *checker.Session) {
+	var imageURLs []string
+	var assetURLs []string
+	start := time.Now()
+
+	imagePerPageChecker := checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc, "/")
+		if len(imageURLs) < PostsPerPage {
+			return errors.New("1ページに表示される画像の数が足りません")
+		}
+		return nil
+	})
+
+	index := checker.NewAction("GET", "/")
+	index.ExpectedLocation = `^/$`
+	index.Description = "インデックスページが表示できること"
+	index.CheckFunc = imagePerPageChecker
+	err := index.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	loadAssets(ctx, s, assetURLs)
+	loadImages(ctx, s, imageURLs)
+
+	offset := util.RandomNumber(10) // 10は適当。URLをバラけさせるため
+	for i := 0; i < 10; i++ {       // 10ページ辿る
+		maxCreatedAt := time.Date(2016, time.January, 2, 11, 46, 21-PostsPerPage*i+offset, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+
+		imageURLs = []string{}
+		posts := checker.NewAction("GET", "/posts?max_created_at="+url.QueryEscape(maxCreatedAt.Format(time.RFC3339)))
+		posts.Description = "インデックスページの「もっと見る」が表示できること"
+		posts.CheckFunc = imagePerPageChecker
+		err := posts.Play(ctx, s)
+		if err != nil {
+			return
+		}
+
+		loadImages(ctx, s, imageURLs)
+
+		if time.Since(start) > WaitAfterTimeout {
+			break
+		}
+	}
+}
+
+// インデックスページを5回表示するだけ（負荷かける用）
+// WaitAfterTimeout秒たったら問答無用で打ち切る
+func loadIndexScenario(ctx context.Context, s *checker.Session) {
+	var imageURLs []string
+	var assetURLs []string
+	start := time.Now()
+
+	imagePerPageChecker := checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc, "/")
+		if len(imageURLs) < PostsPerPage {
+			return errors.New("1ページに表示される画像の数が足りません")
+		}
+		return nil
+	})
+
+	index := checker.NewAction("GET", "/")
+	index.ExpectedLocation = `^/$`
+	index.Description = "インデックスページが表示できること"
+	index.CheckFunc = imagePerPageChecker
+	err := index.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	loadAssets(ctx, s, assetURLs)
+	loadImages(ctx, s, imageURLs)
+
+	for i := 0; i < 4; i++ {
+		// あとの4回はDOMをパースしない。トップページをキャッシュして超高速に返されたとき対策
+		index := checker.NewAction("GET", "/")
+		index.ExpectedLocation = `^/$`
+		index.Description = "インデックスページが表示できること"
+		err := index.Play(ctx, s)
+		if err != nil {
+			return
+		}
+
+		loadAssets(ctx, s, assetURLs) // 静的ファイルも初回と同じもの
+		loadImages(ctx, s, imageURLs) // 画像は初回と同じものにリクエスト投げる
+
+		if time.Since(start) > WaitAfterTimeout {
+			break
+		}
+	}
+}
+
+// /@:account_name のページにアクセスして投稿ページをいくつか開いていく
+// WaitAfterTimeout秒たったら問答無用で打ち切る
+func userAndPostPageScenario(ctx context.Context, s *checker.Session, accountName string) {
+	var imageURLs []string
+	var assetURLs []string
+	var postLinks []string
+	start := time.Now()
+
+	userPage := checker.NewAction("GET", "/@"+accountName)
+	userPage.Description = "ユーザーページ"
+	userPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc, "/@"+accountName)
+		postLinks = extractPostLinks(doc)
+		return nil
+	})
+	err := userPage.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	loadAssets(ctx, s, assetURLs)
+	loadImages(ctx, s, imageURLs)
+
+	for _, link := range postLinks {
+		postPage := checker.NewAction("GET", link)
+		postPage.Description = "投稿単体ページが表示できること"
+		postPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+			imageURLs = extractImages(doc)
+			assetURLs = extractAssets(doc, link)
+			if len(imageURLs) < 1 {
+				return errors.New("投稿単体ページに投稿画像が表示されていません")
+			}
+			return nil
+		})
+		err := postPage.Play(ctx, s)
+		if err != nil {
+			return
+		}
+
+		loadAssets(ctx, s, assetURLs)
+		loadImages(ctx, s, imageURLs)
+
+		if time.Since(start) > WaitAfterTimeout {
+			break
+		}
+	}
+}
+
+// ログインして /@:account_name のページにアクセスして一番上の投稿にコメントする
+// 簡略化のために画像や静的ファイルへのアクセスはスキップする
+func commentScenario(ctx context.Context, s *checker.Session, me User, accountName string, sentence string) {
+	var csrfToken string
+	var postID string
+	var ok bool
+
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "ログインできること"
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	userPage := checker.NewAction("GET", "/@"+accountName)
+	userPage.Description = "ユーザーページが表示できること"
+	userPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+
+		sel := doc.Find(`div.isu-post`).First()
+
+		if sel.Length() == 0 {
+			return nil // 1枚も投稿が無いユーザー
+		}
+
+		csrfToken, ok = sel.Find(`input[name="csrf_token"]`).First().Attr("value")
+		if !ok {
+			return errors.New("CSRFトークンが取得できません")
+		}
+
+		postID, ok = sel.Find(`input[name="post_id"]`).First().Attr("value")
+		if !ok {
+			return errors.New("post_idが取得できません")
+		}
+
+		return nil
+	})
+	err = userPage.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	if postID == "" {
+		return
+	}
+
+	comment := checker.NewAction("POST", "/comment")
+	comment.ExpectedLocation = "^/posts/" + postID + "$"
+	comment.PostData = map[string]string{
+		"post_id":    postID,
+		"comment":    sentence,
+		"csrf_token": csrfToken,
+	}
+	err = comment.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	runStateFromContext(ctx).created.AddComment(postID, sentence)
+}
+
+// ログインして画像を投稿する
+// 簡略化のために画像や静的ファイルへのアクセスはスキップする
+func postImageScenario(ctx context.Context, s *checker.Session, me User, image *checker.Asset, sentence string) {
+	var csrfToken string
+	var imageURLs []string
+	var postID string
+	var createdAt string
+	var ok bool
+
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "ログインできること"
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+
+		csrfToken, ok = doc.Find(`input[name="csrf_token"]`).First().Attr("value")
+		if !ok {
+			return errors.New("CSRFトークンが取得できません")
+		}
+
+		return nil
+	})
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	postImage := checker.NewUploadAction("POST", "/", "file")
+	postImage.Description = "画像を投稿してリダイレクトされること"
+	postImage.ExpectedLocation = `^/posts/\d+$`
+	postImage.Asset = image
+	postImage.PostData = map[string]string{
+		"body":       sentence,
+		"csrf_token": csrfToken,
+	}
+	postImage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		if len(imageURLs) < 1 {
+			return errors.New("投稿した画像が表示されていません")
+		}
+		postID, _ = doc.Find(`input[name="post_id"]`).First().Attr("value")
+		createdAt, _ = doc.Find(`#pid_` + postID).Attr("data-created-at")
+		return nil
+	})
+
+	err = postImage.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	if postID != "" {
+		runStateFromContext(ctx).created.AddPost(postID, me.AccountName, sentence, image)
+	}
+
+	getImage := checker.NewImageAction(imageURLs[0], image)
+	getImage.Description = "投稿した画像と一致すること"
+	err = getImage.Play(ctx, s)
+	if err != nil || postID == "" {
+		return
+	}
+
+	checkPostVisibility(ctx, s, postID, createdAt)
+}
+
+// 新しい投稿がfreshnessWindow以内にインデックスページと「もっと見る」に表示されることを確認する
+// インデックスページをキャッシュしていて、投稿したときに破棄していないと失敗する
+func checkPostVisibility(ctx context.Context, s *checker.Session, postID string, createdAt string) {
+	t, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return // 投稿日時が取れないと「もっと見る」のURLが作れないので確認しない
+	}
+
+	listed := checkHTML(func(doc *goquery.Document) error {
+		return checkPostListed(doc, postID, t)
+	})
+	freshnessWindow := runStateFromContext(ctx).freshnessWindow
+
+	index := checker.NewPollAction("GET", "/", freshnessWindow)
+	index.Description = "投稿した画像がすぐにインデックスページに表示されること"
+	index.CheckFunc = listed
+	err = index.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	posts := checker.NewPollAction("GET", "/posts?max_created_at="+url.QueryEscape(createdAt), freshnessWindow)
+	posts.Description = "投稿した画像がすぐに「もっと見る」に表示されること"
+	posts.CheckFunc = listed
+	posts.Play(ctx, s)
+}
+
+// 投稿が一覧に表示されているか確認する
+// 同時に投稿された新しい投稿で1ページ目から押し出されている場合は表示されているとみなす
+func checkPostListed(doc *goquery.Document, postID string, createdAt time.Time) error {
+	if doc.Find(`#pid_`+postID).Length() > 0 {
+		return nil
+	}
+
+	posts := doc.Find(`.isu-post`)
+	if posts.Length() >= PostsPerPage {
+		last, _ := posts.Last().Attr("data-created-at")
+		t, err := time.Parse(time.RFC3339, last)
+		if err == nil && !t.Before(createdAt) {
+			return nil
+		}
+	}
+
+	return errors.New("投稿した画像が一覧に表示されていません")
+}
+
+// 適当なユーザー名でログインしようとする
+// ログインできないことをチェック
+func cannotLoginNonexistentUserScenario(ctx context.Context, s *checker.Session) {
+	fakeAccountName := util.RandomLUNStr(util.RandomNumber(15) + 10)
+	fakeUser := map[string]string{
+		"account_name": fakeAccountName,
+		"password":     fakeAccountName,
+	}
+
+	login := checker.NewAction("POST", "/login")
+	login.Description = "存在しないユーザー名でログインできないこと"
+	login.Critical = true
+	// ログインできるとトップページにリダイレクトされる
+	login.BypassLocation = `^/$`
+	login.ExpectedLocation = `^/login$`
+	login.PostData = fakeUser
+	login.ExpectedHTML = map[string]string{`#notice-message`: "アカウント名かパスワードが間違っています"}
+
+	login.Play(ctx, s)
+}
+
+// 誤ったパスワードでログインできない
+func cannotLoginWrongPasswordScenario(ctx context.Context, s *checker.Session, me User) {
+	fakeUser := map[string]string{
+		"account_name": me.AccountName,
+		"password":     util.RandomLUNStr(util.RandomNumber(15) + 10),
+	}
+
+	login := checker.NewAction("POST", "/login")
+	login.Description = "間違ったパスワードでログインできないこと"
+	login.Critical = true
+	// ログインできるとトップページにリダイレクトされる
+	login.BypassLocation = `^/$`
+	login.ExpectedLocation = `^/login$`
+	login.PostData = fakeUser
+	login.ExpectedHTML = map[string]string{`#notice-message`: "アカウント名かパスワードが間違っています"}
+
+	login.Play(ctx, s)
+}
+
+// 管理者ユーザーでないなら /admin/banned にアクセスできない
+func cannotAccessAdminScenario(ctx context.Context, s *checker.Session, me User) {
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "Adminユーザーでログインできること"
+
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	adminPage := checker.NewAction("GET", "/admin/banned")
+	adminPage.ExpectedStatusCode = http.StatusForbidden
+	adminPage.Critical = true
+	adminPage.BypassLocation = `^/admin/banned$`
+
+	adminPage.Play(ctx, s)
+}
+
+// 間違ったCSRF Tokenで画像を投稿できない
+func cannotPostWrongCSRFTokenScenario(ctx context.Context, s *checker.Session, me User, image *checker.Asset) {
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "正しくログインできること"
+
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	postImage := checker.NewUploadAction("POST", "/", "file")
+	postImage.ExpectedStatusCode = 422
+	postImage.Description = "間違ったCSRFトークンでは画像を投稿できないこと"
+	postImage.Critical = true
+	// 投稿できると投稿のページにリダイレクトされる
+	postImage.BypassLocation = `^/posts/\d+$`
+	postImage.Asset = image
+	postImage.PostData = map[string]string{
+		"body":       util.RandomLUNStr(25),
+		"csrf_token": util.RandomLUNStr(64),
+	}
+	postImage.Play(ctx, s)
+}
+
+// ログインすると右上にアカウント名が出て、ログインしないとアカウント名が出ない
+// 画像のキャッシュにSet-Cookieを含んでいた場合、/にアカウント名が含まれる
+func loginScenario(ctx context.Context, s *checker.Session, me User) {
+	var imageURLs []string
+	var assetURLs []string
+
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "ログインするとユーザー名が表示されること"
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+
+		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc, "/")
+
+		name := doc.Find(`.isu-account-name`).Text()
+		if name == "" {
+			return errors.New("ユーザー名が表示されていません")
+		} else if name != me.AccountName {
+			return errors.New("表示されているユーザー名が正しくありません")
+		}
+		return nil
+	})
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	loadAssets(ctx, s, assetURLs)
+	loadImages(ctx, s, imageURLs) // この画像へのアクセスでSet-Cookieされてたら失敗する
+
+	logout := checker.NewAction("GET", "/logout")
+	logout.ExpectedLocation = `^/$`
+	logout.Description = "ログアウトするとユーザー名が表示されないこと"
+	logout.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+
+		imageURLs = extractImages(doc)
+		assetURLs = extractAssets(doc, "/")
+
+		name := doc.Find(`.isu-account-name`).Text()
+		if name != "" {
+			return errors.New("ログアウトしてもユーザー名が表示されています")
+		}
+		return nil
+	})
+	s.SetAccountName("")
+	err = logout.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	loadAssets(ctx, s, assetURLs)
+	loadImages(ctx, s, imageURLs)
+}
+
+// 新規登録→画像投稿→banされる
+func banScenario(ctx context.Context, s1, s2 *checker.Session, u User, admin User, image *checker.Asset, sentence string) {
+	var csrfToken string
+	var imageURLs []string
+	var userID string
+	var postID string
+	var createdAt string
+	var ok bool
+	accountName := util.RandomLUNStr(25)
+	password := util.RandomLUNStr(25)
+	body := util.RandomLUNStr(15)
+
+	register := checker.NewAction("POST", "/register")
+	register.ExpectedLocation = `^/$`
+	register.Description = "新規登録できること"
+	register.PostData = map[string]string{
+		"account_name": accountName,
+		"password":     password,
+	}
+	register.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		name := doc.Find(`.isu-account-name`).Text()
+		if name == "" {
+			return errors.New("ユーザー名が表示されていません")
+		} else if name != accountName {
+			return errors.New("表示されているユーザー名が正しくありません")
+		}
+		csrfToken, ok = doc.Find(`input[name="csrf_token"]`).First().Attr("value")
+		if !ok {
+			return errors.New("CSRFトークンが取得できません")
+		}
+
+		return nil
+	})
+	s1.SetAccountName(accountName)
+	err := register.Play(ctx, s1)
+	if err != nil {
+		return
+	}
+
+	runStateFromContext(ctx).created.AddUser(accountName, password)
+
+	postImage := checker.NewUploadAction("POST", "/", "file")
+	postImage.Description = "画像を投稿してリダイレクトされること"
+	postImage.ExpectedLocation = `^/posts/\d+$`
+	postImage.Asset = image
+	postImage.PostData = map[string]string{
+		"body":       body,
+		"csrf_token": csrfToken,
+	}
+	postImage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		if len(imageURLs) < 1 {
+			return errors.New("投稿した画像が表示されていません")
+		}
+		postID, _ = doc.Find(`input[name="post_id"]`).First().Attr("value")
+		createdAt, _ = doc.Find(`#pid_` + postID).Attr("data-created-at")
+		return nil
+	})
+	err = postImage.Play(ctx, s1)
+	if err != nil {
+		return // 画像が表示されていないケースは上のCheckFuncの中で既にエラーにしてある
+	}
+
+	if postID != "" {
+		runStateFromContext(ctx).created.AddPost(postID, accountName, body, image)
+	}
+
+	imageURL := imageURLs[0]
+
+	getImage := checker.NewImageAction(imageURL, image)
+	getImage.Description = "投稿した画像と一致することを確認"
+	err = getImage.Play(ctx, s1)
+	if err != nil {
+		return
+	}
+
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "管理ユーザーでログインできること"
+	login.PostData = map[string]string{
+		"account_name": admin.AccountName,
+		"password":     admin.Password,
+	}
+	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		for _, url := range imageURLs {
+			if url == imageURL {
+				return nil // 投稿した画像が正しく表示されている
+			}
+		}
+		// 同時に投稿された新しい投稿で押し出されている場合は確認できないので成功とする
+		if t, err := time.Parse(time.RFC3339, createdAt); err == nil && checkPostListed(doc, postID, t) == nil {
+			return nil
+		}
+		return errors.New("投稿した画像が表示されていません")
+	})
+	s2.SetAccountName(admin.AccountName)
+	err = login.Play(ctx, s2)
+	if err != nil {
+		return
+	}
+
+	banPage := checker.NewAction("GET", "/admin/banned")
+	banPage.Description = "管理ユーザーが管理ページにアクセスできること"
+	banPage.ExpectedLocation = `^/admin/banned$`
+	banPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		csrfToken, ok = doc.Find(`input[name="csrf_token"]`).First().Attr("value")
+		if !ok {
+			return errors.New("CSRFトークンが取得できません")
+		}
+		userID, ok = doc.Find(`input[data-account-name="` + accountName + `"]`).First().Attr("value")
+		if !ok {
+			return errors.New("新規登録されたユーザーが管理ページに表示されていません")
+		}
+		return nil
+	})
+	err = banPage.Play(ctx, s2)
+	if err != nil {
+		return
+	}
+
+	ban := checker.NewAction("POST", "/admin/banned")
+	ban.Description = "ユーザーの禁止ができること"
+	ban.ExpectedLocation = `^/admin/banned$`
+	ban.PostData = map[string]string{
+		"uid[]":      userID,
+		"csrf_token": csrfToken,
+	}
+	err = ban.Play(ctx, s2)
+	if err != nil {
+		return
+	}
+
+	runStateFromContext(ctx).created.BanUser(accountName)
+
+	index := checker.NewAction("GET", "/")
+	index.Description = "トップページに禁止ユーザーの画像が表示されていないこと"
+	index.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		imageURLs = extractImages(doc)
+		for _, url := range imageURLs {
+			if url == imageURL {
+				return errors.New("禁止ユーザーの画像が表示されています")
+			}
+		}
+		return nil
+	})
+	index.Play(ctx, s2)
+}
+
+// 本文やコメントに埋め込むHTMLっぽい文字列
+// エスケープされていなければDOMの構造が変わったり、.isu-xssの要素が現れたりする
+var xssPayloads = []string{
+	`<script>alert("isu")</script>`,
+	`"><img src="/favicon.ico" class="isu-xss">`,
+	`'><b class='isu-xss'>&amp;</b>`,
+	`</div></div><div class="isu-xss">`,
+}
+
+func randomXSSPayload() string {
+	return xssPayloads[util.RandomNumber(len(xssPayloads))]
+}
+
+// 1件の投稿の本文とコメントがエスケープされて表示されていることを確認する
+// commentが空文字列ならコメントはチェックしない
+func checkEscapedPost(sel *goquery.Selection, body string, comment string) error {
+	if sel.Find(`script, .isu-xss`).Length() > 0 {
+		return errors.New("投稿やコメントに含まれるHTMLがエスケープされていません")
+	}
+
+	if !strings.Contains(sel.Find(`.isu-post-text`).Text(), body) {
+		return errors.New("投稿の本文が正しく表示されていません")
+	}
+
+	if comment == "" {
+		return nil
+	}
+
+	found := false
+	sel.Find(`.isu-comment-text`).EachWithBreak(func(_ int, c *goquery.Selection) bool {
+		found = c.Text() == comment
+		return !found
+	})
+	if !found {
+		return errors.New("コメントが正しく表示されていません")
+	}
+
+	return nil
+}
+
+// ログインしてHTMLを含む本文の画像を投稿し、HTMLを含むコメントをする
+// インデックス、「もっと見る」、投稿単体ページ、ユーザーページのすべてでエスケープされていることを確認する
+// 投稿単体ページ以外は最新の3件のコメントしか表示しないので、コメントが表示されていることは投稿単体ページだけで確認する
+// 簡略化のために画像や静的ファイルへのアクセスはスキップする
+func escapeScenario(ctx context.Context, s *checker.Session, me User, image *checker.Asset, sentence string) {
+	var csrfToken string
+	var postID string
+	var createdAt string
+	var ok bool
+
+	body := sentence + randomXSSPayload() + sentence
+	comment := randomXSSPayload() + sentence
+
+	login := checker.NewAction("POST", "/login")
+	login.ExpectedLocation = `^/$`
+	login.Description = "ログインできること"
+	login.PostData = map[string]string{
+		"account_name": me.AccountName,
+		"password":     me.Password,
+	}
+	login.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		csrfToken, ok = doc.Find(`input[name="csrf_token"]`).First().Attr("value")
+		if !ok {
+			return errors.New("CSRFトークンが取得できません")
+		}
+		return nil
+	})
+	s.SetAccountName(me.AccountName)
+	err := login.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	postImage := checker.NewUploadAction("POST", "/", "file")
+	postImage.Description = "HTMLを含む本文で画像を投稿できること"
+	postImage.ExpectedLocation = `^/posts/\d+$`
+	postImage.Asset = image
+	postImage.PostData = map[string]string{
+		"body":       body,
+		"csrf_token": csrfToken,
+	}
+	postImage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		sel := doc.Find(`div.isu-post`).First()
+		postID, ok = sel.Find(`input[name="post_id"]`).First().Attr("value")
+		if !ok {
+			return errors.New("post_idが取得できません")
+		}
+		createdAt, ok = sel.Attr("data-created-at")
+		if !ok {
+			return errors.New("投稿日時が取得できません")
+		}
+		return checkEscapedPost(sel, body, "")
+	})
+	err = postImage.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	runStateFromContext(ctx).created.AddPost(postID, me.AccountName, body, image)
+
+	postComment := checker.NewAction("POST", "/comment")
+	postComment.Description = "HTMLを含むコメントができること"
+	postComment.ExpectedLocation = "^/posts/" + postID + "$"
+	postComment.PostData = map[string]string{
+		"post_id":    postID,
+		"comment":    comment,
+		"csrf_token": csrfToken,
+	}
+	// リダイレクト先の投稿単体ページにはすべてのコメントが表示される
+	postComment.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		return checkEscapedPost(doc.Find(`#pid_`+postID), body, comment)
+	})
+	err = postComment.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	runStateFromContext(ctx).created.AddComment(postID, comment)
+
+	// インデックスと「もっと見る」は他の投稿に押し出されて表示されないことがあるので、表示されているときだけ確認する
+	index := checker.NewAction("GET", "/")
+	index.Description = "インデックスページで本文がエスケープされていること"
+	index.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		sel := doc.Find(`#pid_` + postID)
+		if sel.Length() == 0 {
+			return nil
+		}
+		return checkEscapedPost(sel, body, "")
+	})
+	err = index.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	posts := checker.NewAction("GET", "/posts?max_created_at="+url.QueryEscape(createdAt))
+	posts.Description = "「もっと見る」で本文がエスケープされていること"
+	posts.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		sel := doc.Find(`#pid_` + postID)
+		if sel.Length() == 0 {
+			return nil
+		}
+		return checkEscapedPost(sel, body, "")
+	})
+	err = posts.Play(ctx, s)
+	if err != nil {
+		return
+	}
+
+	userPage := checker.NewAction("GET", "/@"+me.AccountName)
+	userPage.Description = "ユーザーページで本文がエスケープされていること"
+	userPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+		sel := doc.Find(`#pid_` + postID)
+		if sel.Length() == 0 {
+			return errors.New("投稿した画像がユーザーページに表示されていません")
+		}
+		return checkEscapedPost(sel, body, "")
+	})
+	userPage.Play(ctx, s)
+}
+
+// 負荷走行中に作成したユーザー、投稿、コメントから最大samples件ずつ選び、正しく表示されることを確認する
+// 書き込みを後回しにするキャッシュなどで書き込みが失われていると失敗する
+func auditScenario(ctx context.Context, s *checker.Session, samples int) {
+	created := runStateFromContext(ctx).created
+
+	for _, u := range created.SampleUsers(samples) {
+		userPage := checker.NewAction("GET", "/@"+u.AccountName)
+		if u.Banned {
+			userPage.Description = "banしたユーザーのページが表示されないこと"
+			userPage.ExpectedStatusCode = http.StatusNotFound
+		} else {
+			userPage.Description = "ベンチマーク中に登録したユーザーのページが表示されること"
+		}
+		userPage.Play(ctx, s)
+	}
+
+	for _, p := range created.SamplePosts(samples) {
+		var imageURLs []string
+		p := p
+
+		postPage := checker.NewAction("GET", "/posts/"+p.ID)
+		if p.AccountName != "" && created.IsBanned(p.AccountName) {
+			postPage.Description = "banしたユーザーの投稿が表示されないこと"
+			postPage.ExpectedStatusCode = http.StatusNotFound
+			postPage.Play(ctx, s)
+			continue
+		}
+
+		postPage.Description = "ベンチマーク中に作成した投稿とコメントが表示されること"
+		postPage.CheckFunc = checkHTML(func(doc *goquery.Document) error {
+			sel := doc.Find(`#pid_` + p.ID)
+			if sel.Length() == 0 {
+				return errors.New("ベンチマーク中に作成した投稿が表示されていません")
+			}
+
+			if p.Body != "" && !strings.Contains(sel.Find(`.isu-post-text`).Text(), p.Body) {
+				return errors.New("ベンチマーク中に作成した投稿の本文が正しくありません")
+			}
+
+			comments := map[string]int{}
+			sel.Find(`.isu-comment-text`).Each(func(_ int, c *goquery.Selection) {
+				comments[c.Text()]++
+			})
+			for _, c := range p.Comments {
+				if comments[c] == 0 {
+					return errors.New("ベンチマーク中にしたコメントが表示されていません")
+				}
+				comments[c]--
+			}
+
+			imageURLs = extractImages(doc)
+			return nil
+		})
+		err := postPage.Play(ctx, s)
+		if err != nil || p.Image == nil || len(imageURLs) < 1 {
+			continue
+		}
+
+		getImage := checker.NewImageAction(imageURLs[0], p.Image)
+		getImage.Description = "ベンチマーク中に投稿した画像と一致すること"
+		getImage.Play(ctx, s)
+	}
+}
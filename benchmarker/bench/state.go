@@ -0,0 +1,180 @@
+package bench
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// 1回のベンチマークで使う状態
+// 前の実行のシナリオが残っていても混ざらないように、実行ごとに作りctxでシナリオに渡す
+type runState struct {
+	// ベンチマーク中に作成したもの
+	created *createdStore
+	// 静的ファイルのURLのパスと期待するMD5
+	assetDigests map[string]string
+	// 新しい投稿が一覧に表示されるまでの猶予
+	freshnessWindow time.Duration
+}
+
+func newRunState() *runState {
+	return &runState{
+		created:         newCreatedStore(),
+		assetDigests:    defaultAssetDigests(),
+		freshnessWindow: FreshnessWindow,
+	}
+}
+
+type runStateContextKey struct{}
+
+func withRunState(ctx context.Context, st *runState) context.Context {
+	return context.WithValue(ctx, runStateContextKey{}, st)
+}
+
+// Runの外からシナリオを呼んだときのための状態
+var defaultRunState = newRunState()
+
+// ctxで渡された状態を返す。なければRunの外から呼ばれたときの状態を返す
+func runStateFromContext(ctx context.Context) *runState {
+	if st, ok := ctx.Value(runStateContextKey{}).(*runState); ok {
+		return st
+	}
+	return defaultRunState
+}
+
+// ベンチマーク中に作成したユーザー
+type createdUser struct {
+	AccountName string
+	Password    string
+	Banned      bool
+}
+
+// ベンチマーク中に作成した投稿とその投稿へのコメント
+type createdPost struct {
+	ID          string
+	AccountName string
+	Body        string
+	Image       *checker.Asset
+	Comments    []string
+}
+
+// ベンチマーク中に作成したものを記録しておき、負荷走行後に消えていないか確認する
+type createdStore struct {
+	sync.Mutex
+	users map[string]*createdUser
+	posts map[string]*createdPost
+}
+
+func newCreatedStore() *createdStore {
+	return &createdStore{
+		users: make(map[string]*createdUser),
+		posts: make(map[string]*createdPost),
+	}
+}
+
+func (c *createdStore) AddUser(accountName, password string) {
+	c.Lock()
+	c.users[accountName] = &createdUser{AccountName: accountName, Password: password}
+	c.Unlock()
+}
+
+func (c *createdStore) BanUser(accountName string) {
+	c.Lock()
+	if u, ok := c.users[accountName]; ok {
+		u.Banned = true
+	}
+	c.Unlock()
+}
+
+func (c *createdStore) AddPost(id, accountName, body string, image *checker.Asset) {
+	c.Lock()
+	c.posts[id] = &createdPost{ID: id, AccountName: accountName, Body: body, Image: image}
+	c.Unlock()
+}
+
+// 作成した投稿以外へのコメントは、投稿ごとの記録がないので作る
+func (c *createdStore) AddComment(postID, comment string) {
+	c.Lock()
+	p, ok := c.posts[postID]
+	if !ok {
+		p = &createdPost{ID: postID}
+		c.posts[postID] = p
+	}
+	p.Comments = append(p.Comments, comment)
+	c.Unlock()
+}
+
+var imagePathRegexp = regexp.MustCompile(`^/image/(\d+)\.`)
+
+// 投稿画像のURLから、ベンチマーク中に投稿した画像を返す
+// ベンチマーカーが投稿した画像でなければnil
+func (c *createdStore) ImageAsset(imageURL string) *checker.Asset {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return nil
+	}
+	m := imagePathRegexp.FindStringSubmatch(u.Path)
+	if m == nil {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if p, ok := c.posts[m[1]]; ok {
+		return p.Image
+	}
+	return nil
+}
+
+// 投稿者がbanされているか
+func (c *createdStore) IsBanned(accountName string) bool {
+	c.Lock()
+	defer c.Unlock()
+	u, ok := c.users[accountName]
+	return ok && u.Banned
+}
+
+// 最大n件をランダムに選ぶ
+func (c *createdStore) SampleUsers(n int) []createdUser {
+	c.Lock()
+	users := make([]createdUser, 0, len(c.users))
+	for _, u := range c.users {
+		users = append(users, *u)
+	}
+	c.Unlock()
+
+	shuffle(len(users), func(i, j int) { users[i], users[j] = users[j], users[i] })
+	if len(users) > n {
+		users = users[:n]
+	}
+	return users
+}
+
+// 最大n件をランダムに選ぶ
+func (c *createdStore) SamplePosts(n int) []createdPost {
+	c.Lock()
+	posts := make([]createdPost, 0, len(c.posts))
+	for _, p := range c.posts {
+		cp := *p
+		cp.Comments = append([]string(nil), p.Comments...)
+		posts = append(posts, cp)
+	}
+	c.Unlock()
+
+	shuffle(len(posts), func(i, j int) { posts[i], posts[j] = posts[j], posts[i] })
+	if len(posts) > n {
+		posts = posts[:n]
+	}
+	return posts
+}
+
+func shuffle(n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, util.RandomNumber(i+1))
+	}
+}
@@ -0,0 +1,98 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+// エンドポイントごとの、ネットワークの段階ごとの所要時間
+// connectが長ければacceptのキューが詰まっていて、ttfbが長ければアプリケーションの処理に時間がかかっている
+type TimingOutput struct {
+	Endpoint string `json:"endpoint"`
+	Actions  int64  `json:"actions"`
+	// 新しく接続したリクエストの数。dns、connect、tlsは新しく接続したアクションだけを集計する
+	NewConnections int64 `json:"new_connections"`
+
+	DNS      util.DurationSummary `json:"dns"`
+	Connect  util.DurationSummary `json:"connect"`
+	TLS      util.DurationSummary `json:"tls"`
+	TTFB     util.DurationSummary `json:"ttfb"`
+	Transfer util.DurationSummary `json:"transfer"`
+}
+
+type endpointTimings struct {
+	actions        int64
+	newConnections int64
+
+	dns      util.Durations
+	connect  util.Durations
+	tls      util.Durations
+	ttfb     util.Durations
+	transfer util.Durations
+}
+
+type timingStats struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointTimings
+}
+
+func newTimingStats() *timingStats {
+	return &timingStats{endpoints: map[string]*endpointTimings{}}
+}
+
+func (t *timingStats) observe(r checker.ActionRecord) {
+	// ウォームアップ中のものと、キャッシュを使ってリクエストを送らなかったものは数えない
+	if r.Warmup || (r.StatusCode == 0 && r.Timing == (checker.Timing{})) {
+		return
+	}
+
+	endpoint := endpointOf(r.Method, r.Path)
+
+	t.mu.Lock()
+	e, ok := t.endpoints[endpoint]
+	if !ok {
+		e = &endpointTimings{}
+		t.endpoints[endpoint] = e
+	}
+	e.actions++
+	e.newConnections += int64(r.Timing.NewConnections)
+	t.mu.Unlock()
+
+	if r.Timing.NewConnections > 0 {
+		e.dns.Add(r.Timing.DNS)
+		e.connect.Add(r.Timing.Connect)
+		if r.Timing.TLS > 0 {
+			e.tls.Add(r.Timing.TLS)
+		}
+	}
+	if r.StatusCode != 0 {
+		e.ttfb.Add(r.Timing.TTFB)
+		e.transfer.Add(r.Timing.Transfer)
+	}
+}
+
+func (t *timingStats) outputs() []TimingOutput {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outputs := make([]TimingOutput, 0, len(t.endpoints))
+	for endpoint, e := range t.endpoints {
+		outputs = append(outputs, TimingOutput{
+			Endpoint:       endpoint,
+			Actions:        e.actions,
+			NewConnections: e.newConnections,
+			DNS:            e.dns.Summary(),
+			Connect:        e.connect.Summary(),
+			TLS:            e.tls.Summary(),
+			TTFB:           e.ttfb.Summary(),
+			Transfer:       e.transfer.Summary(),
+		})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Endpoint < outputs[j].Endpoint
+	})
+	return outputs
+}
@@ -0,0 +1,71 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+)
+
+// エンドポイントごとの転送量。圧縮の効果を見るのに使う
+type TransferOutput struct {
+	Endpoint  string `json:"endpoint"`
+	Responses int64  `json:"responses"`
+	// Content-Encodingで圧縮されていたレスポンスの数と、その内訳
+	Compressed int64            `json:"compressed"`
+	Encodings  map[string]int64 `json:"encodings,omitempty"`
+	// 受け取ったバイト数と、展開したあとのバイト数
+	Bytes        int64 `json:"bytes"`
+	DecodedBytes int64 `json:"decoded_bytes"`
+}
+
+type transferStats struct {
+	mu        sync.Mutex
+	endpoints map[string]*TransferOutput
+}
+
+func newTransferStats() *transferStats {
+	return &transferStats{endpoints: map[string]*TransferOutput{}}
+}
+
+func (t *transferStats) observe(r checker.ActionRecord) {
+	// ウォームアップ中のものと、キャッシュを使ってレスポンスを受け取らなかったものは数えない
+	if r.Warmup || r.StatusCode == 0 {
+		return
+	}
+
+	endpoint := endpointOf(r.Method, r.Path)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o, ok := t.endpoints[endpoint]
+	if !ok {
+		o = &TransferOutput{Endpoint: endpoint}
+		t.endpoints[endpoint] = o
+	}
+	o.Responses++
+	o.Bytes += r.Bytes
+	o.DecodedBytes += r.DecodedBytes
+	if r.ContentEncoding != "" {
+		o.Compressed++
+		if o.Encodings == nil {
+			o.Encodings = map[string]int64{}
+		}
+		o.Encodings[r.ContentEncoding]++
+	}
+}
+
+func (t *transferStats) outputs() []TransferOutput {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outputs := make([]TransferOutput, 0, len(t.endpoints))
+	for _, o := range t.endpoints {
+		outputs = append(outputs, *o)
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].Endpoint < outputs[j].Endpoint
+	})
+	return outputs
+}
@@ -0,0 +1,239 @@
+package bench
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/catatsuy/private-isu/benchmarker/checker"
+	"github.com/catatsuy/private-isu/benchmarker/util"
+)
+
+func prepareUserdata(userdata string) ([]User, []User, []User, []string, []*checker.Asset, error) {
+	if userdata == "" {
+		return nil, nil, nil, nil, nil, errors.New("userdataディレクトリが指定されていません")
+	}
+	info, err := os.Stat(userdata)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, nil, nil, nil, errors.New("userdataがディレクトリではありません")
+	}
+
+	users, bannedUsers, adminUsers, err := loadUsers(userdata)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	sentenceFile, err := os.Open(userdata + "/kaomoji.txt")
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	defer sentenceFile.Close()
+
+	sentences := []string{}
+
+	sScanner := bufio.NewScanner(sentenceFile)
+	for sScanner.Scan() {
+		sentence := sScanner.Text()
+		sentences = append(sentences, sentence)
+	}
+
+	imgs, err := filepath.Glob(userdata + "/img/000*") // 00001.jpg, 00002.png, 00003.gif など
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	images := []*checker.Asset{}
+
+	for _, img := range imgs {
+		data, err := os.ReadFile(img)
+		if err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+
+		imgType := ""
+		if strings.HasSuffix(img, "jpg") {
+			imgType = "image/jpeg"
+		} else if strings.HasSuffix(img, "png") {
+			imgType = "image/png"
+		} else if strings.HasSuffix(img, "gif") {
+			imgType = "image/gif"
+		}
+
+		images = append(images, &checker.Asset{
+			MD5:  util.GetMD5(data),
+			Path: img,
+			Type: imgType,
+		})
+	}
+
+	return users, bannedUsers, adminUsers, sentences, images, err
+}
+
+const (
+	RoleAdmin  = "admin"
+	RoleNormal = "normal"
+	RoleBanned = "banned"
+)
+
+// userdataディレクトリのmanifest.jsonの形式
+type UserManifest struct {
+	Users []UserManifestEntry `json:"users"`
+}
+
+type UserManifestEntry struct {
+	AccountName string   `json:"account_name"`
+	Password    string   `json:"password"`
+	Role        string   `json:"role"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// 通常ユーザー、banされたユーザー、管理者ユーザーを読み込む
+// manifest.json か manifest.csv があればそれを使い、なければ names.txt の行番号から役割を決める
+func loadUsers(userdata string) ([]User, []User, []User, error) {
+	var entries []UserManifestEntry
+	var err error
+
+	if _, statErr := os.Stat(userdata + "/manifest.json"); statErr == nil {
+		entries, err = loadJSONManifest(userdata + "/manifest.json")
+	} else if _, statErr := os.Stat(userdata + "/manifest.csv"); statErr == nil {
+		entries, err = loadCSVManifest(userdata + "/manifest.csv")
+	} else {
+		entries, err = loadNames(userdata + "/names.txt")
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	users := []User{}
+	bannedUsers := []User{}
+	adminUsers := []User{}
+
+	for i, e := range entries {
+		if e.AccountName == "" || e.Password == "" {
+			return nil, nil, nil, fmt.Errorf("%d人目のユーザーのアカウント名かパスワードが空です", i+1)
+		}
+
+		u := User{AccountName: e.AccountName, Password: e.Password, Tags: e.Tags}
+		switch e.Role {
+		case RoleAdmin:
+			adminUsers = append(adminUsers, u)
+		case RoleNormal:
+			users = append(users, u)
+		case RoleBanned:
+			bannedUsers = append(bannedUsers, u)
+		default:
+			return nil, nil, nil, fmt.Errorf("%sの役割が正しくありません: %s", e.AccountName, e.Role)
+		}
+	}
+
+	if len(users) == 0 || len(adminUsers) == 0 {
+		return nil, nil, nil, errors.New("通常ユーザーと管理者ユーザーがそれぞれ1人以上必要です")
+	}
+
+	return users, bannedUsers, adminUsers, nil
+}
+
+func loadJSONManifest(path string) ([]UserManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest UserManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("manifest.jsonが読み込めません: %w", err)
+	}
+
+	return manifest.Users, nil
+}
+
+// 1行目はヘッダーで account_name,password,role,tags の順に並んでいる
+// tagsは省略でき、複数あるときは;で区切る
+func loadCSVManifest(path string) ([]UserManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	entries := []UserManifestEntry{}
+	header := true
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("manifest.csvが読み込めません: %w", err)
+		}
+		if header {
+			header = false
+			continue
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("manifest.csvの%d行目の列が足りません", len(entries)+2)
+		}
+
+		e := UserManifestEntry{
+			AccountName: record[0],
+			Password:    record[1],
+			Role:        record[2],
+		}
+		if len(record) > 3 && record[3] != "" {
+			e.Tags = strings.Split(record[3], ";")
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// names.txt の形式ではパスワードは名前を2回繰り返したもの
+func loadNames(path string) ([]UserManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []UserManifestEntry{}
+
+	scanner := bufio.NewScanner(file)
+	i := 1
+	for scanner.Scan() {
+		name := scanner.Text()
+		entries = append(entries, UserManifestEntry{
+			AccountName: name,
+			Password:    name + name,
+			Role:        PositionalRole(i),
+		})
+		i++
+	}
+
+	return entries, scanner.Err()
+}
+
+// names.txt の行番号(1始まり)から役割を決める
+// userdata/load.rb と同じく、最初の9人が管理者、10人目以降で50で割れる場合はbanされたユーザー
+func PositionalRole(i int) string {
+	if i < 10 {
+		return RoleAdmin
+	}
+	if i%50 == 0 {
+		return RoleBanned
+	}
+	return RoleNormal
+}
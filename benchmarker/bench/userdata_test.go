@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadUsers_names(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{}
+	for i := 1; i <= 100; i++ {
+		names = append(names, fmt.Sprintf("user%d", i))
+	}
+	err := os.WriteFile(filepath.Join(dir, "names.txt"), []byte(strings.Join(names, "\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, bannedUsers, adminUsers, err := loadUsers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(adminUsers) != 9 || adminUsers[0].AccountName != "user1" {
+		t.Errorf("unexpected admin users: %v", adminUsers)
+	}
+	if len(bannedUsers) != 2 || bannedUsers[0].AccountName != "user50" || bannedUsers[1].AccountName != "user100" {
+		t.Errorf("unexpected banned users: %v", bannedUsers)
+	}
+	if len(users) != 89 || users[0].Password != "user10user10" {
+		t.Errorf("unexpected users: %d", len(users))
+	}
+}
+
+func TestLoadUsers_manifest(t *testing.T) {
+	dir := t.TempDir()
+	csv := "account_name,password,role,tags\n" +
+		"root,rootroot,admin,\n" +
+		"alice,alicepass,normal,heavy;comment\n" +
+		"mallory,mallorypass,banned\n"
+	err := os.WriteFile(filepath.Join(dir, "manifest.csv"), []byte(csv), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, bannedUsers, adminUsers, err := loadUsers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adminUsers) != 1 || len(users) != 1 || len(bannedUsers) != 1 {
+		t.Fatalf("unexpected roles: %v %v %v", adminUsers, users, bannedUsers)
+	}
+	if len(users[0].Tags) != 2 || users[0].Tags[1] != "comment" {
+		t.Errorf("unexpected tags: %v", users[0].Tags)
+	}
+
+	// JSONはCSVより優先される
+	json := `{"users": [{"account_name": "root", "password": "rootroot", "role": "admin"}, {"account_name": "bob", "password": "bobbob", "role": "normal"}]}`
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(json), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, _, _, err = loadUsers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].AccountName != "bob" {
+		t.Errorf("unexpected users: %v", users)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`{"users": [{"account_name": "bob", "password": "bobbob", "role": "owner"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = loadUsers(dir)
+	if err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
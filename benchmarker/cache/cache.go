@@ -2,93 +2,184 @@ package cache
 
 import (
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/catatsuy/private-isu/benchmarker/util"
 	"github.com/marcw/cachecontrol"
 )
 
-type cacheStore struct {
-	sync.RWMutex
-	items map[string]*URLCache
+// ブラウザのキャッシュ(RFC 9111のprivate cache)と同じように振る舞うキャッシュのエントリ
+//
+//   - max-ageかExpiresで新鮮な間はリクエストを送らずにキャッシュから返す
+//   - 新鮮でなくなったものやno-cacheのものはIf-None-MatchやIf-Modified-Sinceを付けて再検証する
+//   - no-storeのものや、Vary: *のものは保存しない
+//   - Varyで指定されたリクエストヘッダーが保存したときと違えば使わない
+//
+// s-maxageは共有キャッシュ向けの指定なので使わない
+// 新鮮でなくなったものを再検証せずに返すことはないので、must-revalidateは常に満たされる
+// immutableは再読み込みでも再検証しないという指定だが、再読み込みはしないので新鮮な間は常にキャッシュから返す
+// Last-Modifiedから新鮮な期間を推測するヒューリスティックは、スコアが揺れないように使わない
+type URLCache struct {
+	LastModified string `json:"last_modified,omitempty"`
+	Etag         string `json:"etag,omitempty"`
+	// この時刻まではリクエストを送らずに使える。ゼロ値なら常に再検証する
+	ExpiresAt    time.Time                  `json:"expires_at"`
+	CacheControl *cachecontrol.CacheControl `json:"cache_control"`
+	MD5          string                     `json:"md5"`
+	// 元の画像から変換されていればその種類。キャッシュを使ったときの採点に使う
+	Transformation string `json:"transformation,omitempty"`
+	// Varyで指定されたリクエストヘッダーと、保存したときの値
+	Vary map[string]string `json:"vary,omitempty"`
+	// レスポンスボディのバイト数。Storeの容量の計算に使う
+	Size int64 `json:"size"`
+
+	// 保存したレスポンスのヘッダー。再検証したときに304のヘッダーと合わせる
+	Header http.Header `json:"header"`
 }
 
-func NewCacheStore() *cacheStore {
-	m := make(map[string]*URLCache)
-	c := &cacheStore{
-		items: m,
+// resを保存できなければnilを返す
+func NewURLCache(res *http.Response, md5 string, size int64) *URLCache {
+	if res.StatusCode != http.StatusOK {
+		return nil
 	}
-	return c
-}
 
-func (c *cacheStore) Get(key string) (*URLCache, bool) {
-	c.RLock()
-	v, found := c.items[key]
-	c.RUnlock()
-	return v, found
-}
+	cc := cachecontrol.Parse(res.Header.Get("Cache-Control"))
+	if cc.NoStore() {
+		return nil
+	}
 
-func (c *cacheStore) Set(key string, value *URLCache) {
-	c.Lock()
-	c.items[key] = value
-	c.Unlock()
-}
+	vary, ok := varyValues(res)
+	if !ok {
+		return nil
+	}
 
-var instance *cacheStore
-var once sync.Once
+	c := &URLCache{
+		LastModified: res.Header.Get("Last-Modified"),
+		Etag:         res.Header.Get("ETag"),
+		ExpiresAt:    expiresAt(res.Header, cc, time.Now()),
+		CacheControl: &cc,
+		MD5:          md5,
+		Vary:         vary,
+		Size:         size,
+		Header:       res.Header.Clone(),
+	}
 
-func GetInstance() *cacheStore {
-	once.Do(func() {
-		instance = NewCacheStore()
-	})
+	// 新鮮な期間も再検証する手段もなければ保存しても使えない
+	if c.ExpiresAt.IsZero() && c.LastModified == "" && c.Etag == "" {
+		return nil
+	}
 
-	return instance
+	return c
 }
 
-type URLCache struct {
-	LastModified string
-	Etag         string
-	ExpiresAt    time.Time
-	CacheControl *cachecontrol.CacheControl
-	MD5          string
+// 再検証で304が返ってきたときに、そのヘッダーで更新したエントリを返す
+// 304に含まれないヘッダーは保存していたものを使う
+func (c *URLCache) Refresh(res *http.Response) *URLCache {
+	h := c.Header.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Del("Age")
+	for k, v := range res.Header {
+		h[k] = v
+	}
+	cc := cachecontrol.Parse(h.Get("Cache-Control"))
+
+	n := *c
+	n.Header = h
+	n.LastModified = h.Get("Last-Modified")
+	n.Etag = h.Get("ETag")
+	n.ExpiresAt = expiresAt(h, cc, time.Now())
+	n.CacheControl = &cc
+	return &n
 }
 
-func NewURLCache(res *http.Response) (*URLCache, string) {
-	directive := res.Header.Get("Cache-Control")
-	cc := cachecontrol.Parse(directive)
-	noCache, _ := cc.NoCache()
-	md5 := util.GetMD5ByIO(res.Body)
-
-	if len(directive) == 0 || noCache || cc.NoStore() {
-		return nil, md5
+// Varyで指定されたリクエストヘッダーが保存したときと同じならtrue
+// hには送るときと同じCookieを含める
+func (c *URLCache) Matches(h http.Header) bool {
+	for name, value := range c.Vary {
+		if headerValue(h, name) != value {
+			return false
+		}
 	}
-
-	now := time.Now()
-	lm := res.Header.Get("Last-Modified")
-	etag := res.Header.Get("ETag")
-
-	return &URLCache{
-		LastModified: lm,
-		Etag:         etag,
-		ExpiresAt:    now.Add(cc.MaxAge()),
-		CacheControl: &cc,
-		MD5:          md5,
-	}, md5
+	return true
 }
 
+// リクエストを送らずに使えるならtrue
 func (c *URLCache) Available() bool {
+	if noCache, _ := c.CacheControl.NoCache(); noCache {
+		return false
+	}
 	return time.Now().Before(c.ExpiresAt)
 }
 
+// 再検証のための条件付きリクエストにする
 func (c *URLCache) Apply(req *http.Request) {
-	if c.Available() {
-		if c.LastModified != "" {
-			req.Header.Add("If-Modified-Since", c.LastModified)
+	if c.LastModified != "" {
+		req.Header.Set("If-Modified-Since", c.LastModified)
+	}
+
+	if c.Etag != "" {
+		req.Header.Set("If-None-Match", c.Etag)
+	}
+}
+
+// 新鮮でなくなる時刻。新鮮な期間がなければゼロ値
+func expiresAt(h http.Header, cc cachecontrol.CacheControl, now time.Time) time.Time {
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = now
+	}
+
+	var lifetime time.Duration
+	if maxAge := cc.MaxAge(); maxAge >= 0 {
+		lifetime = maxAge
+	} else if expires := h.Get("Expires"); expires != "" {
+		// 不正な値は過去の時刻として扱う
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return time.Time{}
 		}
+		lifetime = t.Sub(date)
+	}
 
-		if c.Etag != "" {
-			req.Header.Add("If-None-Match", c.Etag)
+	// 途中のキャッシュにいた時間と、Dateからレスポンスを受け取るまでの時間の長い方をすでに経過したものとする
+	age := now.Sub(date)
+	if a, err := strconv.Atoi(h.Get("Age")); err == nil && time.Duration(a)*time.Second > age {
+		age = time.Duration(a) * time.Second
+	}
+	if age < 0 {
+		age = 0
+	}
+
+	if lifetime <= age {
+		return time.Time{}
+	}
+	return now.Add(lifetime - age)
+}
+
+func varyValues(res *http.Response) (map[string]string, bool) {
+	vary := map[string]string{}
+	for _, v := range res.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			value := ""
+			if res.Request != nil {
+				value = headerValue(res.Request.Header, name)
+			}
+			vary[http.CanonicalHeaderKey(name)] = value
 		}
 	}
+	return vary, true
+}
+
+func headerValue(h http.Header, name string) string {
+	return strings.Join(h.Values(name), ", ")
 }
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newResponse(status int, header map[string]string, reqHeader map[string]string) *http.Response {
+	req, _ := http.NewRequest("GET", "http://localhost/css/style.css", nil)
+	for k, v := range reqHeader {
+		req.Header.Set(k, v)
+	}
+	res := &http.Response{StatusCode: status, Header: http.Header{}, Request: req}
+	for k, v := range header {
+		res.Header.Set(k, v)
+	}
+	return res
+}
+
+func TestNewURLCache_freshness(t *testing.T) {
+	now := time.Now()
+	date := now.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name      string
+		header    map[string]string
+		stored    bool
+		available bool
+	}{
+		{"max-age", map[string]string{"Cache-Control": "max-age=60"}, true, true},
+		{"max-age overrides Expires", map[string]string{"Cache-Control": "max-age=0", "Expires": now.Add(time.Hour).UTC().Format(http.TimeFormat), "ETag": `"a"`}, true, false},
+		{"Expires", map[string]string{"Date": date, "Expires": now.Add(time.Hour).UTC().Format(http.TimeFormat)}, true, true},
+		{"invalid Expires", map[string]string{"Expires": "0", "ETag": `"a"`}, true, false},
+		{"Age", map[string]string{"Cache-Control": "max-age=60", "Age": "120", "ETag": `"a"`}, true, false},
+		{"immutable", map[string]string{"Cache-Control": "public, max-age=31536000, immutable"}, true, true},
+		{"s-maxage is for shared caches", map[string]string{"Cache-Control": "s-maxage=60", "Last-Modified": date}, true, false},
+		{"no-cache", map[string]string{"Cache-Control": "max-age=60, no-cache", "ETag": `"a"`}, true, false},
+		{"validators only", map[string]string{"Last-Modified": date}, true, false},
+		{"no-store", map[string]string{"Cache-Control": "max-age=60, no-store"}, false, false},
+		{"Vary: *", map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, false, false},
+		{"nothing to reuse", map[string]string{}, false, false},
+	}
+
+	for _, tt := range tests {
+		c := NewURLCache(newResponse(http.StatusOK, tt.header, nil), "md5", 0)
+		if (c != nil) != tt.stored {
+			t.Errorf("%s: expected stored=%v", tt.name, tt.stored)
+			continue
+		}
+		if c != nil && c.Available() != tt.available {
+			t.Errorf("%s: expected available=%v", tt.name, tt.available)
+		}
+	}
+
+	if NewURLCache(newResponse(http.StatusNotFound, map[string]string{"Cache-Control": "max-age=60"}, nil), "md5", 0) != nil {
+		t.Error("only 200 responses should be stored")
+	}
+}
+
+func TestURLCache_Matches(t *testing.T) {
+	res := newResponse(http.StatusOK, map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Encoding, Cookie"}, map[string]string{"Accept-Encoding": "gzip"})
+	c := NewURLCache(res, "md5", 0)
+
+	req, _ := http.NewRequest("GET", "http://localhost/css/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if !c.Matches(req.Header) {
+		t.Error("expected to match the same request headers")
+	}
+
+	req.Header.Set("Cookie", "isuconp-go.session=xxx")
+	if c.Matches(req.Header) {
+		t.Error("expected not to match a different Cookie")
+	}
+}
+
+func TestURLCache_Refresh(t *testing.T) {
+	c := NewURLCache(newResponse(http.StatusOK, map[string]string{"Cache-Control": "no-cache", "ETag": `"a"`, "Last-Modified": "Mon, 02 Jan 2006 15:04:05 GMT"}, nil), "md5", 0)
+	req, _ := http.NewRequest("GET", "http://localhost/css/style.css", nil)
+	c.Apply(req)
+	if req.Header.Get("If-None-Match") != `"a"` || req.Header.Get("If-Modified-Since") == "" {
+		t.Errorf("expected conditional headers, got %v", req.Header)
+	}
+
+	n := c.Refresh(newResponse(http.StatusNotModified, map[string]string{"Cache-Control": "max-age=60", "ETag": `"b"`}, nil))
+	if !n.Available() || n.Etag != `"b"` || n.LastModified != c.LastModified || n.MD5 != "md5" {
+		t.Errorf("unexpected refreshed entry: %+v", n)
+	}
+	if c.Available() {
+		t.Error("the original entry should not be modified")
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// ブラウザ1つ分のキャッシュの容量
+const DefaultMaxBytes = 10 << 20
+
+// ブラウザ1つ分のキャッシュ
+// 保存したレスポンスボディの合計がmaxBytesを超えたら、最近使っていないものから捨てる
+type Store struct {
+	sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type storeEntry struct {
+	key   string
+	value *URLCache
+}
+
+func NewStore(maxBytes int64) *Store {
+	return &Store{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *Store) Get(key string) (*URLCache, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, found := c.items[key]
+	if !found {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*storeEntry).value, true
+}
+
+// 保存して、容量を超えたために捨てたエントリの数を返す
+// 容量より大きいものは保存しない
+func (c *Store) Set(key string, value *URLCache) int {
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+	if value.Size > c.maxBytes {
+		return 0
+	}
+
+	c.items[key] = c.ll.PushFront(&storeEntry{key: key, value: value})
+	c.size += value.Size
+
+	evicted := 0
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back().Value.(*storeEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+func (c *Store) Delete(key string) {
+	c.Lock()
+	c.remove(key)
+	c.Unlock()
+}
+
+func (c *Store) remove(key string) {
+	e, found := c.items[key]
+	if !found {
+		return
+	}
+	c.ll.Remove(e)
+	delete(c.items, key)
+	c.size -= e.Value.(*storeEntry).value.Size
+}
+
+func (c *Store) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+// 容量がmaxBytesの複製を返す。入りきらなければ最近使っていないものから捨てる
+func (c *Store) Clone(maxBytes int64) *Store {
+	n := NewStore(maxBytes)
+	for _, e := range c.entries() {
+		n.Set(e.key, e.value)
+	}
+	return n
+}
+
+// 最近使っていないものから順に返す
+func (c *Store) entries() []storeEntry {
+	c.Lock()
+	defer c.Unlock()
+
+	entries := make([]storeEntry, 0, c.ll.Len())
+	for e := c.ll.Back(); e != nil; e = e.Prev() {
+		entries = append(entries, *e.Value.(*storeEntry))
+	}
+	return entries
+}
+
+type persistedEntry struct {
+	Key   string    `json:"key"`
+	Value *URLCache `json:"value"`
+}
+
+// 次回の実行で読み込めるようにfileに書き出す
+func (c *Store) Save(file string) error {
+	entries := c.entries()
+	persisted := make([]persistedEntry, 0, len(entries))
+	for _, e := range entries {
+		persisted = append(persisted, persistedEntry{Key: e.key, Value: e.value})
+	}
+
+	b, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// Saveで書き出したfileを読み込む。fileがなければ空のStoreを返す
+func LoadStore(file string, maxBytes int64) (*Store, error) {
+	c := NewStore(maxBytes)
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	persisted := []persistedEntry{}
+	err = json.Unmarshal(b, &persisted)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range persisted {
+		if e.Value != nil {
+			c.Set(e.Key, e.Value)
+		}
+	}
+	return c, nil
+}
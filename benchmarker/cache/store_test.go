@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_LRU(t *testing.T) {
+	c := NewStore(100)
+	c.Set("/a", &URLCache{MD5: "a", Size: 40})
+	c.Set("/b", &URLCache{MD5: "b", Size: 40})
+
+	// /aを使ったので、次に捨てられるのは/b
+	if _, found := c.Get("/a"); !found {
+		t.Fatal("expected /a to be found")
+	}
+	if evicted := c.Set("/c", &URLCache{MD5: "c", Size: 40}); evicted != 1 {
+		t.Errorf("expected 1 eviction, got %d", evicted)
+	}
+	if _, found := c.Get("/b"); found {
+		t.Error("expected /b to be evicted")
+	}
+
+	// 置き換えたときは古いものの大きさを数えない
+	c.Set("/c", &URLCache{MD5: "c2", Size: 60})
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+
+	if c.Set("/large", &URLCache{Size: 101}); c.Len() != 2 {
+		t.Error("entries larger than the store should not be stored")
+	}
+}
+
+func TestStore_CloneAndSave(t *testing.T) {
+	c := NewStore(100)
+	c.Set("/a", &URLCache{MD5: "a", Size: 40, Vary: map[string]string{"Accept-Encoding": "gzip"}})
+	c.Set("/b", &URLCache{MD5: "b", Size: 40})
+
+	clone := c.Clone(50)
+	if _, found := clone.Get("/a"); found {
+		t.Error("expected the least recently used entry to be dropped in a smaller clone")
+	}
+	if v, found := clone.Get("/b"); !found || v.MD5 != "b" {
+		t.Error("expected /b in the clone")
+	}
+
+	file := filepath.Join(t.TempDir(), "cache.json")
+	if err := c.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStore(file, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, found := loaded.Get("/a"); !found || v.MD5 != "a" || v.Vary["Accept-Encoding"] != "gzip" {
+		t.Errorf("unexpected loaded entry: %+v", v)
+	}
+
+	empty, err := LoadStore(filepath.Join(t.TempDir(), "none.json"), 100)
+	if err != nil || empty.Len() != 0 {
+		t.Errorf("expected an empty store for a missing file, got %v", err)
+	}
+}
@@ -2,16 +2,17 @@ package checker
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 
 	"github.com/catatsuy/private-isu/benchmarker/cache"
+	"github.com/catatsuy/private-isu/benchmarker/util"
 )
 
 type Action struct {
@@ -25,6 +26,16 @@ type Action struct {
 	ExpectedHeaders    map[string]string
 	ExpectedHTML       map[string]string
 
+	// 一時的な失敗のときに再試行する設定。nilならSetDefaultRetryPolicyの設定を使う
+	Retry *RetryPolicy
+
+	// trueならCSRFトークンのチェックや権限のチェックをすり抜けたときに失格にする
+	// すり抜けたと判断するのは、期待と異なるレスポンスが2xxで、リダイレクト先がBypassLocationに一致したときだけ
+	// 5xxなど負荷による失敗は、Criticalでも通常のエラーにする
+	Critical bool
+	// すり抜けたときのリダイレクト先の正規表現。空ならどこでもよい
+	BypassLocation string
+
 	Description string
 
 	CheckFunc func(body io.Reader) error
@@ -38,12 +49,16 @@ const (
 	failErrorScore     = 10
 	failExceptionScore = 20
 	failDelayPostScore = 100
+
+	retryPenaltyScore = 1
 )
 
 type Asset struct {
 	Path string
 	MD5  string
 	Type string
+
+	image imageCache
 }
 
 func NewAction(method, path string) *Action {
@@ -54,48 +69,99 @@ func NewAction(method, path string) *Action {
 	}
 }
 
-func (a *Action) Play(s *Session) error {
+func (a *Action) Play(ctx context.Context, s *Session) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := s.beginAction(ctx)
+	defer func() { s.notify(ctx, a, start, err) }()
+
+	req, res, body, err := a.fetch(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	err = a.checkBody(body)
+	if err != nil {
+		return s.Fail(
+			FailError,
+			res.Request,
+			err,
+		)
+	}
+
+	err = s.checkPrivateData(res, body)
+	if err != nil {
+		return s.Fail(
+			FailCritical,
+			res.Request,
+			err,
+		)
+	}
+
+	s.Success(KindGet, req)
+
+	if a.Method == "POST" {
+		s.Success(KindPost, req)
+	}
+
+	return nil
+}
+
+// リクエストを送り、ステータスコードとリダイレクト先を確認してレスポンスボディを読み込む
+// 失敗したときはs.Failで記録済みのエラーを返す
+func (a *Action) fetch(ctx context.Context, s *Session) (*http.Request, *http.Response, []byte, error) {
 	formData := url.Values{}
 	for key, val := range a.PostData {
 		formData.Set(key, val)
 	}
 
-	buf := bytes.NewBufferString(formData.Encode())
-	req, err := s.NewRequest(a.Method, a.Path, buf)
+	return a.fetchWithBody(ctx, s, []byte(formData.Encode()), "application/x-www-form-urlencoded")
+}
+
+// fetchと同じだが、POST、PUT、PATCHするときのリクエストボディとContent-Typeを指定する
+func (a *Action) fetchWithBody(ctx context.Context, s *Session, reqBody []byte, contentType string) (*http.Request, *http.Response, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	req, err := s.NewRequest(ctx, a.Method, a.Path, bytes.NewReader(reqBody))
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		logError(err)
+		return nil, nil, nil, s.Fail(FailException, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
 		req.Header.Add(key, val)
 	}
 
-	if req.Method == "POST" {
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	switch req.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		req.Header.Set("Content-Type", contentType)
 	}
 
-	res, err := s.SendRequest(req)
+	req, res, err := s.sendWithRetry(ctx, a, req)
 
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
+			return nil, nil, nil, s.Fail(FailException, req, errors.New("リクエストがタイムアウトしました"))
 		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		logError(err)
+		return nil, nil, nil, s.Fail(FailException, req, errors.New("リクエストに失敗しました"))
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != a.ExpectedStatusCode {
-		return s.Fail(failErrorScore, res.Request, fmt.Errorf("response code should be %d, got %d", a.ExpectedStatusCode, res.StatusCode))
+		return nil, nil, nil, s.Fail(a.unexpectedResponseCategory(res), res.Request, fmt.Errorf("response code should be %d, got %d", a.ExpectedStatusCode, res.StatusCode))
 	}
 
 	if a.ExpectedLocation != "" {
 		if !regexp.MustCompile(a.ExpectedLocation).MatchString(res.Request.URL.Path) {
-			return s.Fail(
-				failErrorScore,
+			return nil, nil, nil, s.Fail(
+				a.unexpectedResponseCategory(res),
 				res.Request,
 				fmt.Errorf(
 					"リダイレクト先URLが正しくありません: expected '%s', got '%s'",
@@ -104,29 +170,35 @@ func (a *Action) Play(s *Session) error {
 		}
 	}
 
-	if a.CheckFunc != nil {
-		err := a.CheckFunc(res.Body)
-		if err != nil {
-			return s.Fail(
-				failErrorScore,
-				res.Request,
-				err,
-			)
-		}
+	err = a.checkHeaders(res.Header)
+	if err != nil {
+		return nil, nil, nil, s.Fail(FailError, res.Request, err)
 	}
 
-	s.Success(suceessGetScore)
-
-	if a.Method == "POST" {
-		s.Success(suceessPostScore)
+	body, err := s.readBody(req, res)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return nil
+	return req, res, body, nil
+}
+
+func (a *Action) unexpectedResponseCategory(res *http.Response) FailCategory {
+	if !a.Critical || res.StatusCode < 200 || res.StatusCode >= 300 {
+		return FailError
+	}
+	if a.BypassLocation != "" && !regexp.MustCompile(a.BypassLocation).MatchString(res.Request.URL.Path) {
+		return FailError
+	}
+	return FailCritical
 }
 
 type AssetAction struct {
 	*Action
 	Asset *Asset
+
+	// 投稿画像ならtrue。perceptualモードでは元の画像が分からなくても画像としてデコードできるか確認する
+	image bool
 }
 
 func NewAssetAction(path string, asset *Asset) *AssetAction {
@@ -140,73 +212,148 @@ func NewAssetAction(path string, asset *Asset) *AssetAction {
 	}
 }
 
-func (a *AssetAction) Play(s *Session) error {
+// 投稿画像を読み込むAssetAction
+// 元の画像が分からないときはassetに空のAssetを渡す
+func NewImageAction(path string, asset *Asset) *AssetAction {
+	a := NewAssetAction(path, asset)
+	a.image = true
+	return a
+}
+
+func (a *AssetAction) Play(ctx context.Context, s *Session) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := s.beginAction(ctx)
+	defer func() { s.notify(ctx, a.Action, start, err) }()
+
 	formData := url.Values{}
 	for key, val := range a.PostData {
 		formData.Set(key, val)
 	}
 
 	buf := bytes.NewBufferString(formData.Encode())
-	req, err := s.NewRequest(a.Method, a.Path, buf)
+	req, err := s.NewRequest(ctx, a.Method, a.Path, buf)
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		logError(err)
+		return s.Fail(FailException, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
 		req.Header.Add(key, val)
 	}
 
-	urlCache, cacheFound := cache.GetInstance().Get(a.Path)
+	urlCache, cacheFound := s.cache.Get(a.Path)
+	if cacheFound && !urlCache.Matches(s.cacheRequestHeader(req)) {
+		cacheFound = false
+	}
+
+	// ブラウザと同じように、新鮮なキャッシュがあればリクエストを送らずに使う
+	if cacheFound && urlCache.Available() {
+		s.current.cache = CacheHit
+		s.recorder().Current().SetCacheHit()
+		s.successAsset(urlCache.Transformation, req)
+		return nil
+	}
+
 	if cacheFound {
+		s.current.cache = CacheRevalidated
 		urlCache.Apply(req)
+	} else {
+		s.current.cache = CacheMiss
+		s.recorder().Current().SetCacheMiss()
 	}
 
-	res, err := s.SendRequest(req)
+	req, res, err := s.sendWithRetry(ctx, a.Action, req)
 
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
+			return s.Fail(FailException, req, errors.New("リクエストがタイムアウトしました"))
 		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		logError(err)
+		return s.Fail(FailException, req, errors.New("リクエストに失敗しました"))
 	}
 
-	// 2回io.ReadAllを呼ぶとおかしくなる
-	uc, md5 := cache.NewURLCache(res)
-	if uc != nil {
-		cache.GetInstance().Set(a.Path, uc)
-		if res.StatusCode == http.StatusOK && a.Asset.MD5 == "" {
-			a.Asset.MD5 = md5
-		}
-	} else if a.Asset.MD5 == "" {
-		a.Asset.MD5 = md5
+	defer res.Body.Close()
+
+	if cacheFound {
+		s.recorder().Current().SetCacheRevalidation(res.StatusCode == http.StatusNotModified)
 	}
 
-	success := false
+	// 画像や静的ファイルをキャッシュしているときにSet-Cookieを含めると、他のユーザーとしてログインできてしまう
+	if res.Header.Get("Set-Cookie") != "" {
+		return s.Fail(
+			FailCritical,
+			res.Request,
+			errors.New("静的ファイルや画像のレスポンスにSet-Cookieが含まれています"),
+		)
+	}
 
-	// キャッシュが有効でかつStatusNotModifiedのときは成功
-	if cacheFound && res.StatusCode == http.StatusNotModified {
-		success = true
+	err = a.checkHeaders(res.Header)
+	if err != nil {
+		return s.Fail(FailError, res.Request, err)
 	}
 
-	if res.StatusCode == http.StatusOK &&
-		((uc == nil && md5 == a.Asset.MD5) || uc != nil) {
-		success = true
+	body, err := s.readBody(req, res)
+	if err != nil {
+		return err
 	}
 
-	defer res.Body.Close()
+	success := false
+	transformation := ""
+
+	switch res.StatusCode {
+	case http.StatusNotModified:
+		// キャッシュを再検証したときだけ成功
+		if cacheFound {
+			s.storeCache(a.Path, urlCache.Refresh(res))
+			success = true
+			transformation = urlCache.Transformation
+		}
+	case http.StatusOK:
+		md5 := util.GetMD5(body)
+		known := a.Asset.MD5 != ""
+		if !known {
+			a.Asset.MD5 = md5
+		}
+		success = md5 == a.Asset.MD5
+
+		// MD5が一致しなくても、変換された画像として許容できれば成功
+		if !success {
+			transformation, err = a.Asset.verifyTransformedImage(body)
+			if err != nil {
+				return s.Fail(FailError, res.Request, err)
+			}
+			success = transformation != ""
+		} else if a.image && !known {
+			err = verifyUnknownImage(body)
+			if err != nil {
+				return s.Fail(FailError, res.Request, err)
+			}
+		}
+
+		if success {
+			uc := cache.NewURLCache(res, md5, int64(len(body)))
+			if uc != nil {
+				uc.Transformation = transformation
+				s.storeCache(a.Path, uc)
+			} else {
+				s.cache.Delete(a.Path)
+			}
+		}
+	}
 
 	if !success {
 		return s.Fail(
-			failErrorScore,
+			FailError,
 			res.Request,
 			fmt.Errorf("静的ファイルが正しくありません"),
 		)
 	}
 
-	s.Success(suceessGetScore)
+	s.successAsset(transformation, req)
 
 	return nil
 }
@@ -228,12 +375,19 @@ func NewUploadAction(method, path, uploadParamname string) *UploadAction {
 	}
 }
 
-func (a *UploadAction) Play(s *Session) error {
-	req, err := s.NewFileUploadRequest(a.Path, a.PostData, a.UploadParamName, a.Asset)
+func (a *UploadAction) Play(ctx context.Context, s *Session) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := s.beginAction(ctx)
+	defer func() { s.notify(ctx, a.Action, start, err) }()
+
+	req, err := s.NewFileUploadRequest(ctx, a.Path, a.PostData, a.UploadParamName, a.Asset)
 
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
+		logError(err)
+		return s.Fail(FailException, req, errors.New("リクエストに失敗しました (主催者に連絡してください)"))
 	}
 
 	for key, val := range a.Headers {
@@ -244,17 +398,17 @@ func (a *UploadAction) Play(s *Session) error {
 
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return s.Fail(failExceptionScore, req, errors.New("リクエストがタイムアウトしました"))
+			return s.Fail(FailException, req, errors.New("リクエストがタイムアウトしました"))
 		}
-		fmt.Fprintln(os.Stderr, err)
-		return s.Fail(failExceptionScore, req, errors.New("リクエストに失敗しました"))
+		logError(err)
+		return s.Fail(FailException, req, errors.New("リクエストに失敗しました"))
 	}
 
 	defer res.Body.Close()
 
 	if res.StatusCode != a.ExpectedStatusCode {
 		return s.Fail(
-			failErrorScore,
+			a.unexpectedResponseCategory(res),
 			res.Request,
 			fmt.Errorf("ステータスコードが正しくありません: expected %d, got %d", a.ExpectedStatusCode, res.StatusCode),
 		)
@@ -263,7 +417,7 @@ func (a *UploadAction) Play(s *Session) error {
 	if a.ExpectedLocation != "" {
 		if !regexp.MustCompile(a.ExpectedLocation).MatchString(res.Request.URL.Path) {
 			return s.Fail(
-				failErrorScore,
+				a.unexpectedResponseCategory(res),
 				res.Request,
 				fmt.Errorf(
 					"リダイレクト先URLが正しくありません: expected '%s', got '%s'",
@@ -272,19 +426,36 @@ func (a *UploadAction) Play(s *Session) error {
 		}
 	}
 
-	if a.CheckFunc != nil {
-		err := a.CheckFunc(res.Body)
-		if err != nil {
-			return s.Fail(
-				failErrorScore,
-				res.Request,
-				err,
-			)
-		}
+	err = a.checkHeaders(res.Header)
+	if err != nil {
+		return s.Fail(FailError, res.Request, err)
+	}
+
+	body, err := s.readBody(req, res)
+	if err != nil {
+		return err
+	}
+
+	err = a.checkBody(body)
+	if err != nil {
+		return s.Fail(
+			FailError,
+			res.Request,
+			err,
+		)
+	}
+
+	err = s.checkPrivateData(res, body)
+	if err != nil {
+		return s.Fail(
+			FailCritical,
+			res.Request,
+			err,
+		)
 	}
 
-	s.Success(suceessUploadScore)
-	s.Success(suceessGetScore)
+	s.Success(KindUpload, req)
+	s.Success(KindGet, req)
 
 	return nil
 }
@@ -0,0 +1,89 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+func TestUnexpectedResponseCategory(t *testing.T) {
+	tests := []struct {
+		name     string
+		critical bool
+		status   int
+		path     string
+		expected FailCategory
+	}{
+		{"not critical", false, http.StatusOK, "/", FailError},
+		{"bypassed", true, http.StatusOK, "/", FailCritical},
+		{"server error", true, http.StatusInternalServerError, "/login", FailError},
+		{"bad gateway", true, http.StatusBadGateway, "/", FailError},
+		{"other redirect", true, http.StatusOK, "/register", FailError},
+	}
+
+	for _, tt := range tests {
+		a := &Action{Critical: tt.critical, BypassLocation: `^/$`}
+		req, _ := http.NewRequest("POST", "http://localhost"+tt.path, nil)
+		res := &http.Response{StatusCode: tt.status, Request: req}
+		if c := a.unexpectedResponseCategory(res); c != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, c)
+		}
+	}
+
+	a := &Action{Critical: true}
+	req, _ := http.NewRequest("GET", "http://localhost/admin/banned", nil)
+	if c := a.unexpectedResponseCategory(&http.Response{StatusCode: http.StatusOK, Request: req}); c != FailCritical {
+		t.Errorf("expected any 2xx to be critical without BypassLocation, got %s", c)
+	}
+}
+
+func TestAction_Play_cancelled(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// 送っている途中で中断しても、レスポンスは待って確認する
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := NewAction("GET", "/").Play(ctx, NewSession()); err != nil {
+		t.Errorf("in-flight request should be drained, got %v", err)
+	}
+
+	// 中断した後は新しいリクエストを送らない
+	if err := NewAction("GET", "/").Play(ctx, NewSession()); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+}
+
+func TestAction_Play_recorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// 前の実行のctxで動き続けているアクションは、前の実行の結果に記録する
+	previous, current := score.NewRecorder(), score.NewRecorder()
+	if err := NewAction("GET", "/").Play(score.NewContext(context.Background(), previous), NewSession()); err != nil {
+		t.Fatal(err)
+	}
+	if previous.Score().GetSucesses() != 1 || current.Score().GetSucesses() != 0 {
+		t.Errorf("expected the result in the recorder of ctx: %d, %d", previous.Score().GetSucesses(), current.Score().GetSucesses())
+	}
+}
@@ -0,0 +1,288 @@
+package checker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 失敗したレスポンスのボディを保存する最大のバイト数
+	MaxArtifactBodyBytes = 64 << 10
+	// 保存する失敗の最大数。これを超えた失敗は保存しない
+	MaxArtifacts = 1000
+
+	ArtifactsIndexFile = "index.json"
+
+	redactedValue = "[REDACTED]"
+)
+
+// 失敗したリクエストとレスポンスを保存したファイル
+type Artifact struct {
+	Time      time.Time       `json:"time"`
+	Scenario  string          `json:"scenario,omitempty"`
+	Category  FailCategory    `json:"category"`
+	Message   string          `json:"message"`
+	RequestID string          `json:"request_id,omitempty"`
+	Request   ArtifactRequest `json:"request"`
+	// レスポンスを受け取れなかったときはnil
+	Response *ArtifactResponse `json:"response,omitempty"`
+}
+
+type ArtifactRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers"`
+	// フォームやJSONで送った値。パスワードは伏せる
+	Form map[string][]string `json:"form,omitempty"`
+}
+
+type ArtifactResponse struct {
+	StatusCode int         `json:"status"`
+	Headers    http.Header `json:"headers"`
+	// 展開したあとのボディ。MaxArtifactBodyBytesを超えた分は切り捨てる
+	Body          string `json:"body"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
+}
+
+// index.jsonの1件分
+type ArtifactIndexEntry struct {
+	File      string       `json:"file"`
+	Time      time.Time    `json:"time"`
+	Scenario  string       `json:"scenario,omitempty"`
+	Category  FailCategory `json:"category"`
+	Message   string       `json:"message"`
+	Method    string       `json:"method"`
+	Path      string       `json:"path"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+type artifactWriter struct {
+	mu    sync.Mutex
+	dir   string
+	index []ArtifactIndexEntry
+	// 上限を超えて保存しなかった失敗の数
+	skipped int
+}
+
+var (
+	artifacts   *artifactWriter
+	artifactsMu sync.RWMutex
+)
+
+// 失敗したリクエストとレスポンスをdirに保存するようにする
+func SetArtifactsDir(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	artifactsMu.Lock()
+	artifacts = &artifactWriter{dir: dir, index: []ArtifactIndexEntry{}}
+	artifactsMu.Unlock()
+	return nil
+}
+
+func artifactsEnabled() bool {
+	artifactsMu.RLock()
+	defer artifactsMu.RUnlock()
+	return artifacts != nil
+}
+
+// 保存したファイルと失敗の対応をindex.jsonに書き出す
+func WriteArtifactsIndex() error {
+	artifactsMu.RLock()
+	w := artifacts
+	artifactsMu.RUnlock()
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b, err := json.MarshalIndent(struct {
+		Artifacts []ArtifactIndexEntry `json:"artifacts"`
+		Skipped   int                  `json:"skipped,omitempty"`
+	}{w.index, w.skipped}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.dir, ArtifactsIndexFile), b, 0644)
+}
+
+// 失敗したリクエストと、最後に受け取ったレスポンスを保存する
+// 保存できなくてもベンチマークは続ける
+func (s *Session) saveArtifact(category FailCategory, req *http.Request, reqErr *RequestError) {
+	artifactsMu.RLock()
+	w := artifacts
+	artifactsMu.RUnlock()
+	if w == nil {
+		return
+	}
+
+	a := Artifact{
+		Time:      time.Now(),
+		Scenario:  scenarioFromContext(req.Context()),
+		Category:  category,
+		Message:   reqErr.Err.Error(),
+		RequestID: reqErr.RequestID,
+		Request: ArtifactRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: req.Header.Clone(),
+			Form:    requestForm(req),
+		},
+		Response: s.artifactResponse(),
+	}
+
+	w.mu.Lock()
+	if len(w.index) >= MaxArtifacts {
+		w.skipped++
+		w.mu.Unlock()
+		return
+	}
+	file := fmt.Sprintf("%06d.json", len(w.index)+1)
+	w.index = append(w.index, ArtifactIndexEntry{
+		File:      file,
+		Time:      a.Time,
+		Scenario:  a.Scenario,
+		Category:  category,
+		Message:   a.Message,
+		Method:    reqErr.Method,
+		Path:      reqErr.Path,
+		RequestID: reqErr.RequestID,
+	})
+	w.mu.Unlock()
+
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(w.dir, file), b, 0644)
+	}
+	if err != nil {
+		logError(err)
+	}
+}
+
+func (s *Session) artifactResponse() *ArtifactResponse {
+	res := s.current.response
+	if res == nil {
+		return nil
+	}
+
+	// 失敗した時点でボディを読み込んでいなければ、ここで読み込む
+	if !s.current.body.done && s.current.body.buf.Len() <= MaxArtifactBodyBytes {
+		io.Copy(io.Discard, io.LimitReader(res.Body, int64(MaxArtifactBodyBytes+1-s.current.body.buf.Len())))
+	}
+
+	body := s.current.body.buf.Bytes()
+	truncated := len(body) > MaxArtifactBodyBytes
+	if truncated {
+		body = body[:MaxArtifactBodyBytes]
+	}
+
+	return &ArtifactResponse{
+		StatusCode:    res.StatusCode,
+		Headers:       res.Header.Clone(),
+		Body:          string(body),
+		BodyTruncated: truncated,
+	}
+}
+
+// 失敗を調べるためにボディの先頭を覚えておく
+type artifactBody struct {
+	buf  bytes.Buffer
+	done bool
+}
+
+type capturingReadCloser struct {
+	io.ReadCloser
+	body *artifactBody
+}
+
+func (c *capturingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if rest := MaxArtifactBodyBytes + 1 - c.body.buf.Len(); rest > 0 {
+		if n < rest {
+			rest = n
+		}
+		c.body.buf.Write(p[:rest])
+	}
+	if err != nil {
+		c.body.done = true
+	}
+	return n, err
+}
+
+// フォームやJSONで送った値を、パスワードを伏せて返す
+func requestForm(req *http.Request) map[string][]string {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	form := map[string][]string{}
+	switch mediaType {
+	case "multipart/form-data":
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FileName() != "" {
+				n, _ := io.Copy(io.Discard, part)
+				form[part.FormName()] = append(form[part.FormName()],
+					fmt.Sprintf("(file: %s, %s, %d bytes)", part.FileName(), part.Header.Get("Content-Type"), n))
+				continue
+			}
+			b, _ := io.ReadAll(io.LimitReader(part, MaxArtifactBodyBytes))
+			form[part.FormName()] = append(form[part.FormName()], string(b))
+		}
+	case "application/json":
+		var values map[string]any
+		if json.NewDecoder(body).Decode(&values) != nil {
+			return nil
+		}
+		for k, v := range values {
+			form[k] = []string{jsonString(v)}
+		}
+	default:
+		b, err := io.ReadAll(io.LimitReader(body, MaxArtifactBodyBytes))
+		if err != nil {
+			return nil
+		}
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil
+		}
+		form = values
+	}
+
+	if len(form) == 0 {
+		return nil
+	}
+
+	for k := range form {
+		if strings.Contains(strings.ToLower(k), "password") {
+			form[k] = []string{redactedValue}
+		}
+	}
+	return form
+}
@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveArtifact(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, `<html><body><form method="post" action="/"></form>`+strings.Repeat("x", MaxArtifactBodyBytes)+`</body></html>`)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := SetArtifactsDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		artifactsMu.Lock()
+		artifacts = nil
+		artifactsMu.Unlock()
+	}()
+
+	a := NewAction("POST", "/login")
+	a.PostData = map[string]string{"account_name": "mary", "password": "marymary"}
+	a.CheckFunc = func(r io.Reader) error {
+		return errors.New("CSRFトークンが取得できません")
+	}
+	if err := a.Play(WithScenario(context.Background(), "login"), NewSession()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := WriteArtifactsIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	var index struct {
+		Artifacts []ArtifactIndexEntry `json:"artifacts"`
+	}
+	b, err := os.ReadFile(filepath.Join(dir, ArtifactsIndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Artifacts) != 1 || index.Artifacts[0].Message != "CSRFトークンが取得できません" || index.Artifacts[0].Scenario != "login" {
+		t.Fatalf("unexpected index: %+v", index)
+	}
+
+	var artifact Artifact
+	b, err = os.ReadFile(filepath.Join(dir, index.Artifacts[0].File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &artifact); err != nil {
+		t.Fatal(err)
+	}
+	if got := artifact.Request.Form["password"]; len(got) != 1 || got[0] != redactedValue {
+		t.Errorf("password should be redacted, got %v", got)
+	}
+	if got := artifact.Request.Form["account_name"]; len(got) != 1 || got[0] != "mary" {
+		t.Errorf("unexpected account_name: %v", got)
+	}
+	if artifact.Response == nil || artifact.Response.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response: %+v", artifact.Response)
+	}
+	if !strings.HasPrefix(artifact.Response.Body, "<html><body><form") || len(artifact.Response.Body) != MaxArtifactBodyBytes || !artifact.Response.BodyTruncated {
+		t.Errorf("body should be truncated to %d bytes, got %d", MaxArtifactBodyBytes, len(artifact.Response.Body))
+	}
+	if strings.Contains(string(b), "marymary") {
+		t.Error("password should not be saved")
+	}
+}
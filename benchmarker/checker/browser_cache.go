@@ -0,0 +1,73 @@
+package checker
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+
+	"github.com/catatsuy/private-isu/benchmarker/cache"
+)
+
+// AssetActionでブラウザのキャッシュがどう使われたか。ActionRecordに記録する
+const (
+	// 新鮮なキャッシュを使い、リクエストを送らなかった
+	CacheHit = "hit"
+	// 使えるキャッシュがなく、条件なしのリクエストを送った
+	CacheMiss = "miss"
+	// 条件付きリクエストで再検証した
+	CacheRevalidated = "revalidated"
+)
+
+var (
+	cacheMaxBytes  int64 = cache.DefaultMaxBytes
+	returningUsers float64
+	persistedCache *cache.Store
+	browserCacheMu sync.RWMutex
+)
+
+// Sessionごとのキャッシュの容量と、以前のキャッシュを持った状態で始める仮想ユーザーの割合を設定する
+// persistedがnilでなければ、NewSessionはreturningの確率でpersistedの複製から始める
+// persistedにはすべてのSessionがキャッシュしたものを書き込む
+func SetBrowserCache(maxBytes int64, returning float64, persisted *cache.Store) {
+	browserCacheMu.Lock()
+	cacheMaxBytes = maxBytes
+	returningUsers = returning
+	persistedCache = persisted
+	browserCacheMu.Unlock()
+}
+
+// 以前のキャッシュを持った状態で始めるときはtrueも返す
+func newBrowserCache() (*cache.Store, bool) {
+	browserCacheMu.RLock()
+	defer browserCacheMu.RUnlock()
+
+	if persistedCache != nil && rand.Float64() < returningUsers {
+		return persistedCache.Clone(cacheMaxBytes), true
+	}
+	return cache.NewStore(cacheMaxBytes), false
+}
+
+func (s *Session) storeCache(key string, uc *cache.URLCache) {
+	evicted := s.cache.Set(key, uc)
+	if evicted > 0 {
+		s.recorder().Current().SetCacheEvictions(evicted)
+	}
+
+	browserCacheMu.RLock()
+	defer browserCacheMu.RUnlock()
+	if persistedCache != nil {
+		persistedCache.Set(key, uc)
+	}
+}
+
+// Varyと比べるための、送るときと同じCookieを含めたリクエストヘッダー
+// Cookieはhttp.Clientが送るときに付けるので、送る前のreqには含まれていない
+func (s *Session) cacheRequestHeader(req *http.Request) http.Header {
+	r := &http.Request{Header: req.Header.Clone()}
+	if s.Client.Jar != nil && req.Header.Get("Cookie") == "" {
+		for _, c := range s.Client.Jar.Cookies(req.URL) {
+			r.AddCookie(c)
+		}
+	}
+	return r.Header
+}
@@ -0,0 +1,129 @@
+package checker
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ブラウザと同じように、圧縮されたレスポンスを受け取れることを伝える
+// 自分でAccept-Encodingを付けるとhttp.Transportは展開しないので、SendRequestで展開する
+const AcceptEncoding = "gzip, br, zstd"
+
+// Content-Encodingで圧縮されたレスポンスを展開できなかった
+type DecodeError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Encoding, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Content-Encodingに従ってレスポンスボディを展開する
+// 展開は最初のReadで始めるので、HEADや304のようにボディがなければ何もしない
+type decodingReadCloser struct {
+	body            *errorRecordingReader
+	contentEncoding string
+
+	r       io.Reader
+	closers []func()
+}
+
+// 元のレスポンスボディを読んだときのエラーを覚えておき、展開の失敗と区別する
+type errorRecordingReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (r *errorRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func newDecodingReadCloser(body io.ReadCloser, contentEncoding string) *decodingReadCloser {
+	return &decodingReadCloser{
+		body:            &errorRecordingReader{ReadCloser: body},
+		contentEncoding: contentEncoding,
+	}
+}
+
+func (d *decodingReadCloser) init() error {
+	encodings := strings.Split(d.contentEncoding, ",")
+
+	var r io.Reader = d.body
+	// 最後に適用されたものから順に展開する
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		switch encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, func() { zr.Close() })
+			r = zr
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return err
+			}
+			d.closers = append(d.closers, zr.Close)
+			r = zr
+		default:
+			return errors.New("対応していないContent-Encodingです")
+		}
+	}
+
+	d.r = r
+	return nil
+}
+
+func (d *decodingReadCloser) Read(p []byte) (int, error) {
+	if d.r == nil {
+		err := d.init()
+		if err == io.EOF && d.body.err == nil {
+			// ボディが空
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, d.wrapError(err)
+		}
+	}
+
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		return n, d.wrapError(err)
+	}
+	return n, err
+}
+
+func (d *decodingReadCloser) wrapError(err error) error {
+	// タイムアウトなどレスポンスを受け取るときのエラーはそのまま返す
+	if d.body.err != nil {
+		return d.body.err
+	}
+	return &DecodeError{Encoding: d.contentEncoding, Err: err}
+}
+
+func (d *decodingReadCloser) Close() error {
+	for _, c := range d.closers {
+		c()
+	}
+	return d.body.Close()
+}
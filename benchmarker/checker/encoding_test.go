@@ -0,0 +1,106 @@
+package checker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSendRequest_decode(t *testing.T) {
+	const content = "body { color: #333; } body { color: #333; } body { color: #333; }"
+
+	encoded := map[string][]byte{"": []byte(content), "broken": []byte("not gzip")}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(content))
+	gw.Close()
+	encoded["gzip"] = append([]byte{}, buf.Bytes()...)
+
+	buf.Reset()
+	bw := brotli.NewWriter(&buf)
+	bw.Write([]byte(content))
+	bw.Close()
+	encoded["br"] = append([]byte{}, buf.Bytes()...)
+
+	zw, _ := zstd.NewWriter(nil)
+	encoded["zstd"] = zw.EncodeAll([]byte(content), nil)
+
+	var acceptEncoding string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		encoding := strings.TrimPrefix(r.URL.Path, "/")
+		switch encoding {
+		case "broken":
+			w.Header().Set("Content-Encoding", "gzip")
+		case "":
+		default:
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		if r.Method != "HEAD" {
+			w.Write(encoded[encoding])
+		}
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []string{"", "gzip", "br", "zstd"} {
+		s := NewSession()
+		req, err := s.NewRequest(context.Background(), "GET", "/"+encoding, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.SendRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := s.readBody(req, res)
+		if err != nil {
+			t.Errorf("%s: %s", encoding, err)
+			continue
+		}
+		if string(body) != content {
+			t.Errorf("%s: unexpected body: %q", encoding, body)
+		}
+		if s.current.bytes != int64(len(encoded[encoding])) || s.current.decodedBytes != int64(len(content)) {
+			t.Errorf("%s: unexpected bytes: %d, %d", encoding, s.current.bytes, s.current.decodedBytes)
+		}
+	}
+	if acceptEncoding != AcceptEncoding {
+		t.Errorf("expected Accept-Encoding %q, got %q", AcceptEncoding, acceptEncoding)
+	}
+
+	s := NewSession()
+	req, _ := s.NewRequest(context.Background(), "HEAD", "/gzip", nil)
+	res, err := s.SendRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.readBody(req, res); err != nil {
+		t.Errorf("empty compressed body should be read: %s", err)
+	}
+
+	for _, path := range []string{"/broken", "/deflate"} {
+		req, _ := s.NewRequest(context.Background(), "GET", path, nil)
+		res, err := s.SendRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.readBody(req, res)
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) || reqErr.Category != FailError {
+			t.Errorf("%s: expected decode failure, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ExpectedHeadersの値の書き方
+//
+//	""          ヘッダーがあること
+//	"~pattern"  値が正規表現patternに一致すること
+//	それ以外    値が完全に一致すること
+//
+// ExpectedHTMLはCSSセレクタと、最初に一致した要素の前後の空白を除いたテキストの組
+// 空文字列なら要素が無いかテキストが空であること
+const HeaderRegexpPrefix = "~"
+
+var expectedRegexps sync.Map
+
+func compileExpectedRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := expectedRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	expectedRegexps.Store(pattern, re)
+	return re, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (a *Action) checkHeaders(h http.Header) error {
+	for _, key := range sortedKeys(a.ExpectedHeaders) {
+		expected := a.ExpectedHeaders[key]
+		values, ok := h[http.CanonicalHeaderKey(key)]
+		if !ok {
+			return fmt.Errorf("レスポンスヘッダー %s がありません", key)
+		}
+		got := strings.Join(values, ", ")
+
+		switch {
+		case expected == "":
+		case strings.HasPrefix(expected, HeaderRegexpPrefix):
+			pattern := strings.TrimPrefix(expected, HeaderRegexpPrefix)
+			re, err := compileExpectedRegexp(pattern)
+			if err != nil {
+				return fmt.Errorf("レスポンスヘッダー %s の正規表現が正しくありません (主催者に連絡してください): %s", key, err)
+			}
+			if !re.MatchString(got) {
+				return fmt.Errorf("レスポンスヘッダー %s が正しくありません: expected '%s', got '%s'", key, pattern, got)
+			}
+		default:
+			if got != expected {
+				return fmt.Errorf("レスポンスヘッダー %s が正しくありません: expected '%s', got '%s'", key, expected, got)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Action) checkHTML(body []byte) error {
+	if len(a.ExpectedHTML) == 0 {
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return errors.New("ページのHTMLがパースできませんでした")
+	}
+
+	for _, selector := range sortedKeys(a.ExpectedHTML) {
+		expected := a.ExpectedHTML[selector]
+		got := strings.TrimSpace(doc.Find(selector).First().Text())
+		if got != expected {
+			if expected != "" && got == "" {
+				return fmt.Errorf("%s が表示されていません: expected '%s'", selector, expected)
+			}
+			return fmt.Errorf("%s の表示が正しくありません: expected '%s', got '%s'", selector, expected, got)
+		}
+	}
+	return nil
+}
+
+// ExpectedHTMLとCheckFuncでレスポンスボディを確認する
+func (a *Action) checkBody(body []byte) error {
+	err := a.checkHTML(body)
+	if err != nil {
+		return err
+	}
+
+	if a.CheckFunc != nil {
+		return a.CheckFunc(bytes.NewReader(body))
+	}
+	return nil
+}
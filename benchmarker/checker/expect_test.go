@@ -0,0 +1,57 @@
+package checker
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "private")
+
+	tests := []struct {
+		expected map[string]string
+		ok       bool
+	}{
+		{map[string]string{"Content-Type": "text/html; charset=utf-8"}, true},
+		{map[string]string{"content-type": "~^text/html"}, true},
+		{map[string]string{"Cache-Control": ""}, true},
+		{map[string]string{"Content-Type": "text/html"}, false},
+		{map[string]string{"Content-Type": "~^image/"}, false},
+		{map[string]string{"Content-Type": "~("}, false},
+		{map[string]string{"X-Request-ID": ""}, false},
+	}
+
+	for _, tt := range tests {
+		a := &Action{ExpectedHeaders: tt.expected}
+		err := a.checkHeaders(h)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: expected ok=%v, got %v", tt.expected, tt.ok, err)
+		}
+	}
+}
+
+func TestCheckHTML(t *testing.T) {
+	body := []byte(`<html><body><div id="notice-message"> ログインしてください </div><p class="a">1</p><p class="a">2</p></body></html>`)
+
+	tests := []struct {
+		expected map[string]string
+		ok       bool
+	}{
+		{map[string]string{"#notice-message": "ログインしてください"}, true},
+		{map[string]string{"p.a": "1"}, true},
+		{map[string]string{".isu-account-name": ""}, true},
+		{map[string]string{"#notice-message": ""}, false},
+		{map[string]string{"p.a": "2"}, false},
+		{map[string]string{".isu-account-name": "mary"}, false},
+	}
+
+	for _, tt := range tests {
+		a := &Action{ExpectedHTML: tt.expected}
+		err := a.checkHTML(body)
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: expected ok=%v, got %v", tt.expected, tt.ok, err)
+		}
+	}
+}
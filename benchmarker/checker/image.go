@@ -0,0 +1,239 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"sync"
+)
+
+const (
+	ImageVerifyExact      = "exact"
+	ImageVerifyPerceptual = "perceptual"
+
+	DefaultImageHashThreshold = 10
+
+	// 縮小して返す場合に許容する元画像に対する最小の比率
+	minImageScale = 0.5
+	// 縦横比のずれの許容範囲
+	aspectRatioTolerance = 0.02
+
+	// デコードする画像の縦と横の最大のピクセル数
+	maxImageDimension = 8192
+	// difference hashを計算するときに縮小後の1ピクセルあたり縦横それぞれで読むピクセル数
+	imageHashSamples = 4
+)
+
+// perceptualモードで許容する変換の種類
+const (
+	// 大きさを変えずに再圧縮やフォーマットを変換した
+	TransformTranscoded = "transcoded"
+	// 縦横比を変えずに縮小した
+	TransformResized = "resized"
+)
+
+var (
+	imageVerifyMode    = ImageVerifyExact
+	imageHashThreshold = DefaultImageHashThreshold
+	imageVerifyMu      sync.RWMutex
+)
+
+// 投稿画像の検証方法を設定する
+// exactではMD5が一致する必要がある
+// perceptualではデコードした画像の大きさとperceptual hashを比較し、以下の変換を許容する
+//   - 再圧縮やフォーマットの変換: 大きさが同じでハッシュの距離がthreshold以内
+//   - 縮小: 縦横比が変わらず、元画像の半分以上の大きさでハッシュの距離がthreshold以内
+//
+// 変換された画像の得点はScoringProfileのtransformed_imageの倍率をかけたものになる
+// 元の画像が分からない投稿画像は、画像としてデコードできることだけを確認する
+func SetImageVerifyMode(mode string, threshold int) error {
+	switch mode {
+	case ImageVerifyExact, ImageVerifyPerceptual:
+	default:
+		return fmt.Errorf("unknown image verify mode: %s", mode)
+	}
+	if threshold < 0 || threshold > 64 {
+		return fmt.Errorf("image hash threshold should be between 0 and 64, got %d", threshold)
+	}
+
+	imageVerifyMu.Lock()
+	imageVerifyMode = mode
+	imageHashThreshold = threshold
+	imageVerifyMu.Unlock()
+	return nil
+}
+
+func getImageVerifyMode() (string, int) {
+	imageVerifyMu.RLock()
+	defer imageVerifyMu.RUnlock()
+	return imageVerifyMode, imageHashThreshold
+}
+
+type imageFingerprint struct {
+	Width  int
+	Height int
+	Hash   uint64
+}
+
+type imageCache struct {
+	once        sync.Once
+	fingerprint *imageFingerprint
+	err         error
+}
+
+// 画像をデコードして大きさとdifference hashを計算する
+func newImageFingerprint(data []byte) (*imageFingerprint, error) {
+	// webappが返した画像なので、デコードする前に大きさを確かめてメモリを使い過ぎないようにする
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return nil, fmt.Errorf("image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return nil, errors.New("image is empty")
+	}
+
+	// 9x8に縮小したグレースケール画像の横方向の明暗差を64bitにする
+	// 縮小後の1ピクセルの明るさは、全てのピクセルではなく間隔を空けて読んだピクセルの平均にする
+	const w, h = 9, 8
+	var gray [h][w]float64
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := b.Min.Y + (y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := b.Min.X + (x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var sum float64
+			var n int
+			for py := y0; py < y1; py += imageHashStride(y0, y1) {
+				for px := x0; px < x1; px += imageHashStride(x0, x1) {
+					r, g, bl, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+					n++
+				}
+			}
+			gray[y][x] = sum / float64(n)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return &imageFingerprint{
+		Width:  b.Dx(),
+		Height: b.Dy(),
+		Hash:   hash,
+	}, nil
+}
+
+// [p0, p1)からimageHashSamples個を読むときの間隔
+func imageHashStride(p0, p1 int) int {
+	if stride := (p1 - p0) / imageHashSamples; stride > 1 {
+		return stride
+	}
+	return 1
+}
+
+// 元画像に対して許容できる変換がされた画像かを確認し、変換の種類を返す
+func (expected *imageFingerprint) match(actual *imageFingerprint, threshold int) (string, error) {
+	if actual.Width > expected.Width || actual.Height > expected.Height {
+		return "", errors.New("画像が元の画像より大きくなっています")
+	}
+
+	if float64(actual.Width) < float64(expected.Width)*minImageScale ||
+		float64(actual.Height) < float64(expected.Height)*minImageScale {
+		return "", errors.New("画像が小さくなりすぎています")
+	}
+
+	expectedRatio := float64(expected.Width) / float64(expected.Height)
+	actualRatio := float64(actual.Width) / float64(actual.Height)
+	if actualRatio < expectedRatio*(1-aspectRatioTolerance) || actualRatio > expectedRatio*(1+aspectRatioTolerance) {
+		return "", errors.New("画像の縦横比が変わっています")
+	}
+
+	if bits.OnesCount64(expected.Hash^actual.Hash) > threshold {
+		return "", errors.New("画像の内容が正しくありません")
+	}
+
+	if actual.Width == expected.Width && actual.Height == expected.Height {
+		return TransformTranscoded, nil
+	}
+	return TransformResized, nil
+}
+
+// Assetのファイルから計算した画像の特徴を返す
+// 同じAssetは並列に使われるので一度だけ計算する
+func (a *Asset) imageFingerprint() (*imageFingerprint, error) {
+	a.image.once.Do(func() {
+		data, err := os.ReadFile(a.Path)
+		if err != nil {
+			a.image.err = err
+			return
+		}
+		a.image.fingerprint, a.image.err = newImageFingerprint(data)
+	})
+
+	return a.image.fingerprint, a.image.err
+}
+
+// MD5が一致しなかった画像を、perceptualモードであれば変換されたものとして検証し、変換の種類を返す
+// 検証しなかった場合は空文字列を返す
+func (a *Asset) verifyTransformedImage(data []byte) (string, error) {
+	mode, threshold := getImageVerifyMode()
+	if mode != ImageVerifyPerceptual || a.Path == "" {
+		return "", nil
+	}
+
+	expected, err := a.imageFingerprint()
+	if err != nil {
+		return "", nil
+	}
+
+	actual, err := newImageFingerprint(data)
+	if err != nil {
+		return "", errors.New("画像がデコードできません")
+	}
+
+	return expected.match(actual, threshold)
+}
+
+// 元の画像が分からない投稿画像を、perceptualモードであれば画像としてデコードできるか確認する
+func verifyUnknownImage(data []byte) error {
+	if mode, _ := getImageVerifyMode(); mode != ImageVerifyPerceptual {
+		return nil
+	}
+
+	if _, err := newImageFingerprint(data); err != nil {
+		return errors.New("画像がデコードできません")
+	}
+	return nil
+}
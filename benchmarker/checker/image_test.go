@@ -0,0 +1,95 @@
+package checker
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func gradientImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + y*64/h) % 256)
+			if invert {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+func resize(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, src.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return dst
+}
+
+func fingerprint(t *testing.T, img image.Image, encode func(*bytes.Buffer, image.Image) error) *imageFingerprint {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := newImageFingerprint(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func encodePNG(buf *bytes.Buffer, img image.Image) error {
+	return png.Encode(buf, img)
+}
+
+func encodeJPEG(buf *bytes.Buffer, img image.Image) error {
+	return jpeg.Encode(buf, img, &jpeg.Options{Quality: 60})
+}
+
+func TestImageFingerprint_match(t *testing.T) {
+	original := gradientImage(200, 100, false)
+	expected := fingerprint(t, original, encodePNG)
+
+	// transformationが空なら一致しない
+	tests := []struct {
+		name           string
+		actual         *imageFingerprint
+		transformation string
+	}{
+		{"transcoded", fingerprint(t, original, encodeJPEG), TransformTranscoded},
+		{"resized", fingerprint(t, resize(original, 120, 60), encodeJPEG), TransformResized},
+		{"too small", fingerprint(t, resize(original, 60, 30), encodePNG), ""},
+		{"enlarged", fingerprint(t, resize(original, 400, 200), encodePNG), ""},
+		{"aspect ratio", fingerprint(t, resize(original, 200, 80), encodePNG), ""},
+		{"different image", fingerprint(t, gradientImage(200, 100, true), encodePNG), ""},
+	}
+
+	for _, tt := range tests {
+		transformation, err := expected.match(tt.actual, DefaultImageHashThreshold)
+		if tt.transformation != "" && (err != nil || transformation != tt.transformation) {
+			t.Errorf("%s: expected %s, got %q, %v", tt.name, tt.transformation, transformation, err)
+		}
+		if tt.transformation == "" && err == nil {
+			t.Errorf("%s: expected mismatch", tt.name)
+		}
+	}
+}
+
+func TestImageFingerprint_tooLarge(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, maxImageDimension+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newImageFingerprint(buf.Bytes()); err == nil {
+		t.Error("expected too large image to be rejected")
+	}
+}
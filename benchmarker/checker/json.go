@@ -0,0 +1,499 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONを返すエンドポイントを確認するアクション
+//
+// ExpectedJSONとExtractのキーはJSONPathの一部を使ったパスで、次のように書く
+//
+//	$.posts[0].account_name
+//	$["posts"][0]["account_name"]
+//	posts[0].account_name  ($.は省略できる)
+//
+// ["..."]のキーはGoの文字列リテラルと同じようにエスケープする
+//
+// 数値は精度を落とさないようにjson.Numberとして読み込み、数値として等しければよい
+type JSONAction struct {
+	*Action
+
+	// POST、PUT、PATCHするJSON。nilならPostDataをフォームとして送る
+	Body any
+
+	// パスと期待する値。値はJSONにしたときに等しければよい
+	ExpectedJSON map[string]any
+	// レスポンス全体が満たすべきスキーマ
+	Schema *JSONSchema
+	// パスと値を取り出す先のポインタ。CheckFuncなどの確認がすべて成功してから書き込む
+	// ポインタでないか、ポインタの型に変換できなければ主催者に連絡してもらう失敗にする
+	Extract map[string]any
+}
+
+func NewJSONAction(method, path string) *JSONAction {
+	return &JSONAction{
+		Action: &Action{
+			Method:             method,
+			Path:               path,
+			Headers:            map[string]string{"Accept": "application/json"},
+			ExpectedStatusCode: http.StatusOK,
+			ExpectedHeaders:    map[string]string{"Content-Type": HeaderRegexpPrefix + `^application/json`},
+		},
+	}
+}
+
+func (a *JSONAction) Play(ctx context.Context, s *Session) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := s.beginAction(ctx)
+	defer func() { s.notify(ctx, a.Action, start, err) }()
+
+	for _, path := range sortedAnyKeys(a.Extract) {
+		if rv := reflect.ValueOf(a.Extract[path]); rv.Kind() != reflect.Pointer || rv.IsNil() {
+			return s.Fail(FailException, nil, fmt.Errorf("%s の取り出し先がポインタではありません (主催者に連絡してください): %T", path, a.Extract[path]))
+		}
+	}
+
+	var req *http.Request
+	var res *http.Response
+	var body []byte
+	if a.Body != nil {
+		var reqBody []byte
+		reqBody, err = json.Marshal(a.Body)
+		if err != nil {
+			return s.Fail(FailException, nil, fmt.Errorf("リクエストのJSONが作れません (主催者に連絡してください): %s", err))
+		}
+		req, res, body, err = a.fetchWithBody(ctx, s, reqBody, "application/json")
+	} else {
+		req, res, body, err = a.fetch(ctx, s)
+	}
+	if err != nil {
+		return err
+	}
+
+	doc, err := a.checkJSON(body)
+	if err != nil {
+		return s.Fail(
+			FailError,
+			res.Request,
+			err,
+		)
+	}
+
+	extracted, category, err := a.extract(doc)
+	if err != nil {
+		return s.Fail(
+			category,
+			res.Request,
+			err,
+		)
+	}
+
+	if a.CheckFunc != nil {
+		err = a.CheckFunc(bytes.NewReader(body))
+		if err != nil {
+			return s.Fail(
+				FailError,
+				res.Request,
+				err,
+			)
+		}
+	}
+
+	err = s.checkPrivateData(res, body)
+	if err != nil {
+		return s.Fail(
+			FailCritical,
+			res.Request,
+			err,
+		)
+	}
+
+	// 確認がすべて済んでから書き込む
+	extracted.assign()
+
+	s.Success(KindGet, req)
+
+	switch a.Method {
+	case "POST", "PUT", "PATCH":
+		s.Success(KindPost, req)
+	}
+
+	return nil
+}
+
+// レスポンスのJSONをパースし、SchemaとExpectedJSONを確認する
+func (a *JSONAction) checkJSON(body []byte) (any, error) {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return nil, errors.New("レスポンスのJSONがパースできませんでした")
+	}
+
+	if a.Schema != nil {
+		err = a.Schema.Validate(doc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range sortedAnyKeys(a.ExpectedJSON) {
+		got, err := lookupJSON(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		expected, err := normalizeJSON(a.ExpectedJSON[path])
+		if err != nil {
+			return nil, fmt.Errorf("%s の期待する値がJSONにできません (主催者に連絡してください): %s", path, err)
+		}
+		if !jsonEqual(got, expected) {
+			return nil, fmt.Errorf("%s の値が正しくありません: expected %s, got %s", path, jsonString(expected), jsonString(got))
+		}
+	}
+
+	return doc, nil
+}
+
+// Extractに書き込む前の取り出した値
+type jsonExtraction struct {
+	dsts   []any
+	values []reflect.Value
+}
+
+// 途中で失敗したときに一部だけ書き換わらないように、すべて取り出せてからassignで書き込む
+func (e *jsonExtraction) assign() {
+	for i, dst := range e.dsts {
+		reflect.ValueOf(dst).Elem().Set(e.values[i])
+	}
+}
+
+// Extractのパスの値を取り出す
+// パスがなければwebappの問題、取り出し先の型に変換できなければ呼び出し側の問題として失敗の種類を返す
+func (a *JSONAction) extract(doc any) (*jsonExtraction, FailCategory, error) {
+	e := &jsonExtraction{}
+	for _, path := range sortedAnyKeys(a.Extract) {
+		got, err := lookupJSON(doc, path)
+		if err != nil {
+			return nil, FailError, err
+		}
+		v, err := extractJSON(got, a.Extract[path])
+		if err != nil {
+			return nil, FailException, fmt.Errorf("%s の値を %T に取り出せません (主催者に連絡してください): %s", path, a.Extract[path], jsonString(got))
+		}
+		e.dsts = append(e.dsts, a.Extract[path])
+		e.values = append(e.values, v)
+	}
+	return e, "", nil
+}
+
+// 数値をjson.Numberとして読み込む
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	// json.Unmarshalと同じく、後ろに余計なものがあれば失敗にする
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return v, nil
+}
+
+func sortedAnyKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeJSONで読み込んだときと同じ形にする
+func normalizeJSON(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(b)
+}
+
+// vをdstのポインタが指す型に変換した値を返す。dstには書き込まない
+func extractJSON(v any, dst any) (reflect.Value, error) {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return reflect.Value{}, fmt.Errorf("extract destination should be a non-nil pointer, got %T", dst)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	n := reflect.New(rv.Type().Elem())
+	err = json.Unmarshal(b, n.Interface())
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return n.Elem(), nil
+}
+
+// 数値は表記が違っても値が等しければよい
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, av := range a {
+			bv, ok := b[k]
+			if !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if x, ok := jsonNumber(a); ok {
+		y, ok := jsonNumber(b)
+		return ok && x.Cmp(y) == 0
+	}
+	return a == b
+}
+
+func jsonNumber(v any) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(n))
+	case float64:
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(n), true
+	}
+	return nil, false
+}
+
+func jsonString(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// パスの1要素。keyかindexのどちらか
+type jsonPathStep struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	p := strings.TrimPrefix(path, "$")
+	steps := []jsonPathStep{}
+
+	for len(p) > 0 {
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{key: p[:end], isKey: true})
+			p = p[end:]
+		case strings.HasPrefix(p, `["`):
+			// キーに]が含まれることがあるので、閉じる"まで読んでから]を探す
+			quoted, err := strconv.QuotedPrefix(p[1:])
+			if err != nil || !strings.HasPrefix(p[1+len(quoted):], "]") {
+				return nil, fmt.Errorf("invalid JSON path: %s", path)
+			}
+			key, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{key: key, isKey: true})
+			p = p[1+len(quoted)+1:]
+		case p[0] == '[':
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path: %s", path)
+			}
+			index, err := strconv.Atoi(p[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSON path: %s", path)
+			}
+			steps = append(steps, jsonPathStep{index: index})
+			p = p[end+1:]
+		case len(steps) == 0:
+			// 先頭の$.は省略できる
+			p = "." + p
+		default:
+			return nil, fmt.Errorf("invalid JSON path: %s", path)
+		}
+	}
+
+	return steps, nil
+}
+
+func lookupJSON(doc any, path string) (any, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s (主催者に連絡してください)", err)
+	}
+
+	v := doc
+	for _, step := range steps {
+		if step.isKey {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s がありません", path)
+			}
+			v, ok = obj[step.key]
+			if !ok {
+				return nil, fmt.Errorf("%s がありません", path)
+			}
+			continue
+		}
+
+		arr, ok := v.([]any)
+		if !ok || step.index >= len(arr) {
+			return nil, fmt.Errorf("%s がありません", path)
+		}
+		v = arr[step.index]
+	}
+	return v, nil
+}
+
+// JSON Schemaのうち、よく使うキーワードだけを扱う
+type JSONSchema struct {
+	// object, array, string, number, integer, boolean, null
+	Type       string                 `json:"type,omitempty"`
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *JSONSchema            `json:"items,omitempty"`
+	Enum       []any                  `json:"enum,omitempty"`
+	MinItems   *int                   `json:"minItems,omitempty"`
+	MaxItems   *int                   `json:"maxItems,omitempty"`
+}
+
+func (sc *JSONSchema) Validate(v any) error {
+	return sc.validate(v, "$")
+}
+
+func (sc *JSONSchema) validate(v any, path string) error {
+	if sc.Type != "" && !jsonTypeMatches(sc.Type, v) {
+		return fmt.Errorf("%s の型が正しくありません: expected %s, got %s", path, sc.Type, jsonString(v))
+	}
+
+	if len(sc.Enum) > 0 {
+		found := false
+		for _, e := range sc.Enum {
+			n, err := normalizeJSON(e)
+			if err == nil && jsonEqual(n, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s の値が正しくありません: %s", path, jsonString(v))
+		}
+	}
+
+	switch t := v.(type) {
+	case map[string]any:
+		for _, key := range sc.Required {
+			if _, ok := t[key]; !ok {
+				return fmt.Errorf("%s.%s がありません", path, key)
+			}
+		}
+		keys := make([]string, 0, len(sc.Properties))
+		for key := range sc.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child, ok := t[key]
+			if !ok {
+				continue
+			}
+			err := sc.Properties[key].validate(child, path+"."+key)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		if sc.MinItems != nil && len(t) < *sc.MinItems {
+			return fmt.Errorf("%s の要素が足りません: expected at least %d, got %d", path, *sc.MinItems, len(t))
+		}
+		if sc.MaxItems != nil && len(t) > *sc.MaxItems {
+			return fmt.Errorf("%s の要素が多すぎます: expected at most %d, got %d", path, *sc.MaxItems, len(t))
+		}
+		if sc.Items != nil {
+			for i, child := range t {
+				err := sc.Items.validate(child, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func jsonTypeMatches(typ string, v any) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := jsonNumber(v)
+		return ok
+	case "integer":
+		n, ok := jsonNumber(v)
+		return ok && n.IsInt()
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
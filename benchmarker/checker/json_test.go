@@ -0,0 +1,264 @@
+package checker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+const testPostsJSON = `{"posts": [{"id": 10, "account_name": "mary", "body": "hello", "comments": []}, {"id": 9, "account_name": "bob", "body": "hi", "comments": ["a"]}]}`
+
+func TestLookupJSON(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(testPostsJSON), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected any
+	}{
+		{"$.posts[0].account_name", "mary"},
+		{`$["posts"][1]["body"]`, "hi"},
+		{"posts[1].comments[0]", "a"},
+		{"$.posts[0].id", float64(10)},
+	}
+	for _, tt := range tests {
+		got, err := lookupJSON(doc, tt.path)
+		if err != nil {
+			t.Errorf("%s: %s", tt.path, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.path, tt.expected, got)
+		}
+	}
+
+	for _, path := range []string{"$.posts[2]", "$.posts.id", "$.users", "$..posts", "$.posts[x]", `$["posts"`, `$["posts"x]`} {
+		if _, err := lookupJSON(doc, path); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	minItems := 1
+	schema := &JSONSchema{
+		Type:     "object",
+		Required: []string{"posts"},
+		Properties: map[string]*JSONSchema{
+			"posts": {
+				Type:     "array",
+				MinItems: &minItems,
+				Items: &JSONSchema{
+					Type:     "object",
+					Required: []string{"id", "account_name"},
+					Properties: map[string]*JSONSchema{
+						"id":           {Type: "integer"},
+						"account_name": {Type: "string"},
+						"comments":     {Type: "array", Items: &JSONSchema{Type: "string"}},
+					},
+				},
+			},
+		},
+	}
+
+	var doc any
+	if err := json.Unmarshal([]byte(testPostsJSON), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		t.Errorf("expected valid, got %s", err)
+	}
+
+	for _, invalid := range []string{
+		`{}`,
+		`{"posts": []}`,
+		`{"posts": [{"id": 1.5, "account_name": "mary"}]}`,
+		`{"posts": [{"id": 1}]}`,
+		`{"posts": [{"id": 1, "account_name": "mary", "comments": [1]}]}`,
+	} {
+		var doc any
+		if err := json.Unmarshal([]byte(invalid), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if err := schema.Validate(doc); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestJSONAction_Play(t *testing.T) {
+	var received map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			b, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(b, &received)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, testPostsJSON)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	var id int
+	var name string
+	a := NewJSONAction("POST", "/api/posts")
+	a.Body = map[string]any{"body": "hello"}
+	a.ExpectedJSON = map[string]any{"$.posts[0].body": "hello", "$.posts[1].comments": []string{"a"}}
+	a.Extract = map[string]any{"$.posts[0].id": &id, "$.posts[0].account_name": &name}
+
+	if err := a.Play(context.Background(), NewSession()); err != nil {
+		t.Fatal(err)
+	}
+	if id != 10 || name != "mary" {
+		t.Errorf("unexpected extracted values: %d, %s", id, name)
+	}
+	if received["body"] != "hello" {
+		t.Errorf("request body should be sent as JSON, got %v", received)
+	}
+
+	var wrongType int
+	a = NewJSONAction("GET", "/api/posts")
+	a.Extract = map[string]any{"$.posts[0].account_name": &wrongType}
+	var reqErr *RequestError
+	if err := a.Play(context.Background(), NewSession()); !errors.As(err, &reqErr) || reqErr.Category != FailException {
+		t.Errorf("expected exception for wrong type, got %v", err)
+	}
+
+	a = NewJSONAction("GET", "/api/posts")
+	a.ExpectedJSON = map[string]any{"$.posts[0].id": 9}
+	if err := a.Play(context.Background(), NewSession()); err == nil {
+		t.Error("expected error for wrong value")
+	}
+}
+
+func TestParseJSONPath_bracketKey(t *testing.T) {
+	steps, err := parseJSONPath(`$["a]b"]["c\"d"][0]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []jsonPathStep{{key: "a]b", isKey: true}, {key: `c"d`, isKey: true}, {index: 0}}
+	if len(steps) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, steps)
+	}
+	for i := range steps {
+		if steps[i] != expected[i] {
+			t.Errorf("step %d: expected %v, got %v", i, expected[i], steps[i])
+		}
+	}
+}
+
+func TestJSONAction_checkJSON(t *testing.T) {
+	body := []byte(`{"id": 9007199254740993, "score": 1.0, "name": "mary"}`)
+
+	// float64では9007199254740992になってしまう
+	var id int64
+	a := NewJSONAction("GET", "/api/me")
+	a.ExpectedJSON = map[string]any{"$.id": int64(9007199254740993), "$.score": 1}
+	a.Extract = map[string]any{"$.id": &id}
+	doc, err := a.checkJSON(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, _, err := a.extract(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.assign()
+	if id != 9007199254740993 {
+		t.Errorf("expected 9007199254740993, got %d", id)
+	}
+
+	a.ExpectedJSON = map[string]any{"$.id": int64(9007199254740992)}
+	if _, err := a.checkJSON(body); err == nil {
+		t.Error("expected error for wrong large value")
+	}
+
+	// 取り出し先の型に変換できないのは呼び出し側の問題
+	var wrongType int
+	a = NewJSONAction("GET", "/api/me")
+	a.Extract = map[string]any{"$.id": &id, "$.score": &wrongType}
+	if _, category, err := a.extract(doc); err == nil || category != FailException {
+		t.Errorf("expected exception for wrong type, got %q, %v", category, err)
+	}
+
+	a.Extract = map[string]any{"$.id": &id, "$.missing": &wrongType}
+	if _, category, err := a.extract(doc); err == nil || category != FailError {
+		t.Errorf("expected error for missing path, got %q, %v", category, err)
+	}
+}
+
+func TestJSONAction_extractAfterChecks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testPostsJSON)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// CheckFuncで失敗したときは書き換えない
+	name := "unchanged"
+	a := NewJSONAction("GET", "/api/posts")
+	a.Extract = map[string]any{"$.posts[0].account_name": &name}
+	a.CheckFunc = func(r io.Reader) error {
+		return errors.New("invalid")
+	}
+	if err := a.Play(context.Background(), NewSession()); err == nil {
+		t.Error("expected error from CheckFunc")
+	}
+	if name != "unchanged" {
+		t.Errorf("targets should not be updated on failure: %s", name)
+	}
+
+	// 取り出し先がポインタでないのは主催者に連絡してもらう失敗にする
+	a = NewJSONAction("GET", "/api/posts")
+	a.Extract = map[string]any{"$.posts[0].account_name": name}
+	if err := a.Play(context.Background(), NewSession()); err == nil || !strings.Contains(err.Error(), "主催者に連絡してください") {
+		t.Errorf("expected exception for non-pointer destination, got %v", err)
+	}
+}
+
+func TestJSONAction_contentType(t *testing.T) {
+	contentTypes := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentTypes[r.Method] = r.Header.Get("Content-Type")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{}`)
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"POST", "PUT", "PATCH"} {
+		rec := score.NewRecorder()
+		a := NewJSONAction(method, "/api/posts/1")
+		a.Body = map[string]any{"body": "hello"}
+		if err := a.Play(score.NewContext(context.Background(), rec), NewSession()); err != nil {
+			t.Fatal(err)
+		}
+		if contentTypes[method] != "application/json" {
+			t.Errorf("%s: expected application/json, got %q", method, contentTypes[method])
+		}
+
+		// 書き込むリクエストはPOSTと同じ得点にする
+		if got := rec.Score().GetScore(); got != suceessGetScore+suceessPostScore {
+			t.Errorf("%s: expected score %d, got %d", method, suceessGetScore+suceessPostScore, got)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	pollInitialInterval = 100 * time.Millisecond
+	pollMaxInterval     = time.Second
+)
+
+// 書き込みが反映されるまで、ExpectedHTMLとCheckFuncが通るまで間隔を空けながら繰り返しリクエストするアクション
+// Windowを過ぎても反映されなければ反映の遅延として減点する
+// ステータスコードの誤りなど、それ以外の失敗は繰り返さずにすぐ失敗にする
+type PollAction struct {
+	*Action
+	Window time.Duration
+}
+
+func NewPollAction(method, path string, window time.Duration) *PollAction {
+	return &PollAction{
+		Window: window,
+		Action: &Action{
+			Method:             method,
+			Path:               path,
+			ExpectedStatusCode: http.StatusOK,
+		},
+	}
+}
+
+func (a *PollAction) Play(ctx context.Context, s *Session) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	start := s.beginAction(ctx)
+	defer func() { s.notify(ctx, a.Action, start, err) }()
+
+	interval := pollInitialInterval
+
+	for {
+		req, res, body, err := a.fetch(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		err = s.checkPrivateData(res, body)
+		if err != nil {
+			return s.Fail(
+				FailCritical,
+				res.Request,
+				err,
+			)
+		}
+
+		err = a.checkBody(body)
+		if err == nil {
+			s.Success(KindGet, req)
+			return nil
+		}
+
+		// Windowを過ぎてから確認しても反映されていなければ諦める
+		remaining := a.Window - time.Since(start)
+		if remaining <= 0 {
+			return s.Fail(
+				FailDelay,
+				res.Request,
+				fmt.Errorf("%s (%s以内に反映されませんでした)", err, a.Window),
+			)
+		}
+
+		// 最後はWindowちょうどに確認する
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		interval *= 2
+		if interval > pollMaxInterval {
+			interval = pollMaxInterval
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// visibleAfter回目のリクエストから新しい投稿を返すサーバー
+func newPollServer(t *testing.T, visibleAfter int) (*httptest.Server, func() []time.Time) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		n := len(times)
+		mu.Unlock()
+
+		if n >= visibleAfter {
+			io.WriteString(w, "new post")
+			return
+		}
+		io.WriteString(w, "old post")
+	}))
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	return ts, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time{}, times...)
+	}
+}
+
+func checkNewPost(r io.Reader) error {
+	b, _ := io.ReadAll(r)
+	if !strings.Contains(string(b), "new post") {
+		return errors.New("新しい投稿がありません")
+	}
+	return nil
+}
+
+func TestPollAction_backoff(t *testing.T) {
+	ts, requestTimes := newPollServer(t, 4)
+	defer ts.Close()
+
+	a := NewPollAction("GET", "/", 5*time.Second)
+	a.CheckFunc = checkNewPost
+	if err := a.Play(context.Background(), NewSession()); err != nil {
+		t.Fatal(err)
+	}
+
+	times := requestTimes()
+	if len(times) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(times))
+	}
+
+	// 100ms、200ms、400msと間隔を倍にする
+	for i, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		gap := times[i+1].Sub(times[i])
+		if gap < expected || gap >= 2*expected {
+			t.Errorf("interval %d: expected about %s, got %s", i, expected, gap)
+		}
+	}
+}
+
+func TestPollAction_window(t *testing.T) {
+	ts, requestTimes := newPollServer(t, 100)
+	defer ts.Close()
+
+	a := NewPollAction("GET", "/", 250*time.Millisecond)
+	a.CheckFunc = checkNewPost
+	err := a.Play(context.Background(), NewSession())
+
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Category != FailDelay {
+		t.Fatalf("expected delay failure, got %v", err)
+	}
+
+	// 0ms、100ms、Windowちょうどの250msに確認して諦める
+	times := requestTimes()
+	if len(times) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(times))
+	}
+	if last := times[2].Sub(times[0]); last < 250*time.Millisecond || last > 350*time.Millisecond {
+		t.Errorf("last request should be at the end of the window, got %s", last)
+	}
+}
+
+func TestPollAction_visibleBeforeDeadline(t *testing.T) {
+	var first time.Time
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if first.IsZero() {
+			first = time.Now()
+		}
+		elapsed := time.Since(first)
+		mu.Unlock()
+
+		// Windowの1秒が終わる直前に反映される
+		if elapsed >= 900*time.Millisecond {
+			io.WriteString(w, "new post")
+			return
+		}
+		io.WriteString(w, "old post")
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewPollAction("GET", "/", time.Second)
+	a.CheckFunc = checkNewPost
+	if err := a.Play(context.Background(), NewSession()); err != nil {
+		t.Errorf("post visible within the window should not fail: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package checker
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/marcw/cachecontrol"
+)
+
+// 覚えておくCSRFトークンの数。超えたら古いものから忘れる
+const maxCSRFTokens = 10000
+
+var (
+	// CSRFトークンとそれを発行されたセッションの対応
+	csrfTokenOwners = newCSRFTokenOwnerMap(maxCSRFTokens)
+
+	// テンプレート通りのCSRFトークンが含まれているか
+	// 属性の順番などが変わっているとチェックを飛ばすことになるが、毎回DOMをパースするよりはマシ
+	filledCSRFTokenRegexp = regexp.MustCompile(`name="csrf_token"\s+value="[^"]`)
+)
+
+// 他のセッションのアカウント名やCSRFトークンが表示されていないことを確認する
+// キャッシュしてはいけないページをnginxなどでキャッシュしていると失敗する
+func (s *Session) checkPrivateData(res *http.Response, body []byte) error {
+	if !strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		return nil
+	}
+
+	if !bytes.Contains(body, []byte("isu-account-name")) && !filledCSRFTokenRegexp.Match(body) {
+		return nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return errors.New("ページのHTMLがパースできませんでした")
+	}
+
+	name := doc.Find(`.isu-account-name`).Text()
+	if name != "" && name != s.accountName {
+		return errors.New("他のユーザーのアカウント名が表示されています")
+	}
+
+	tokens := []string{}
+	doc.Find(`input[name="csrf_token"]`).Each(func(_ int, sel *goquery.Selection) {
+		if token, ok := sel.Attr("value"); ok && token != "" {
+			tokens = append(tokens, token)
+		}
+	})
+
+	for _, token := range tokens {
+		if csrfTokenOwners.loadOrStore(token, s.id) != s.id {
+			return errors.New("他のユーザーのCSRFトークンが表示されています")
+		}
+	}
+
+	if cachecontrol.Parse(res.Header.Get("Cache-Control")).Public() && (name != "" || len(tokens) > 0) {
+		return errors.New("Cache-Control: publicなレスポンスにユーザー固有の情報が含まれています")
+	}
+
+	return nil
+}
+
+// CSRFトークンを発行されたセッションのIDを、maxTokens個まで覚えておく
+// Sessionへのポインタを持つと、終わったSessionのClientやキャッシュが解放されなくなるのでIDを持つ
+type csrfTokenOwnerMap struct {
+	mu     sync.Mutex
+	owners map[string]uint64
+	// 覚えた順のトークン。maxTokens個を超えたら先頭から忘れる
+	tokens    []string
+	maxTokens int
+}
+
+func newCSRFTokenOwnerMap(maxTokens int) *csrfTokenOwnerMap {
+	return &csrfTokenOwnerMap{
+		owners:    map[string]uint64{},
+		maxTokens: maxTokens,
+	}
+}
+
+// tokenを最初に表示されたセッションのIDを返す。初めて見たtokenならsessionIDを覚えて返す
+func (m *csrfTokenOwnerMap) loadOrStore(token string, sessionID uint64) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if owner, ok := m.owners[token]; ok {
+		return owner
+	}
+
+	if len(m.tokens) >= m.maxTokens {
+		delete(m.owners, m.tokens[0])
+		m.tokens = m.tokens[1:]
+	}
+	m.owners[token] = sessionID
+	m.tokens = append(m.tokens, token)
+	return sessionID
+}
+
+func (m *csrfTokenOwnerMap) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.owners = map[string]uint64{}
+	m.tokens = nil
+}
@@ -0,0 +1,37 @@
+package checker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCSRFTokenOwnerMap(t *testing.T) {
+	m := newCSRFTokenOwnerMap(2)
+
+	if owner := m.loadOrStore("a", 1); owner != 1 {
+		t.Errorf("expected the first session to own the token, got %d", owner)
+	}
+	if owner := m.loadOrStore("a", 2); owner != 1 {
+		t.Errorf("expected the token to be owned by session 1, got %d", owner)
+	}
+
+	// 上限を超えたら古いものから忘れる
+	m.loadOrStore("b", 2)
+	m.loadOrStore("c", 3)
+	if owner := m.loadOrStore("a", 4); owner != 4 {
+		t.Errorf("expected the oldest token to be forgotten, got owner %d", owner)
+	}
+	if len(m.owners) != 2 || len(m.tokens) != 2 {
+		t.Errorf("expected 2 tokens, got %v", m.owners)
+	}
+}
+
+func TestCSRFTokenOwnerMap_bounded(t *testing.T) {
+	m := newCSRFTokenOwnerMap(100)
+	for i := 0; i < 10000; i++ {
+		m.loadOrStore(fmt.Sprint(i), uint64(i))
+	}
+	if len(m.owners) != 100 || len(m.tokens) != 100 {
+		t.Errorf("expected 100 tokens, got %d", len(m.owners))
+	}
+}
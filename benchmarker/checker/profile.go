@@ -0,0 +1,244 @@
+package checker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 成功したアクションの種類
+const (
+	KindGet    = "get"
+	KindPost   = "post"
+	KindUpload = "upload"
+)
+
+// 失敗の種類
+type FailCategory string
+
+const (
+	// レスポンスの内容が正しくない
+	FailError FailCategory = "error"
+	// タイムアウトなどでレスポンスが得られない
+	FailException FailCategory = "exception"
+	// 書き込みの反映が遅れている
+	FailDelay FailCategory = "delay"
+	// 他のユーザーの情報の漏洩やCSRF対策のすり抜けなど、失格にするべき失敗
+	FailCritical FailCategory = "critical"
+)
+
+var failBaseScores = map[FailCategory]int64{
+	FailError:     failErrorScore,
+	FailException: failExceptionScore,
+	FailDelay:     failDelayPostScore,
+	FailCritical:  failErrorScore,
+}
+
+// 得点の配分
+// コンテストごとに配点を変えるときはJSONファイルで指定する
+//
+//	{
+//	  "success": {"get": 1, "post": 2, "upload": 5},
+//	  "endpoints": {"POST /register": {"post": 10}, "GET /posts/*": {"get": 2}},
+//	  "penalty_multiplier": {"error": 1, "exception": 2, "delay": 1},
+//	  "retry_penalty": 1,
+//	  "transformed_image": {"transcoded": 1, "resized": 0.5}
+//	}
+//
+// endpointsのキーは「メソッド パス」で、パスにはpath.Matchのパターンが使える
+// パスが完全に一致するものを優先し、複数のパターンに一致するときは長いパターンを優先する
+// transformed_imageはperceptualモードで変換された画像として許容したときに、getの得点にかける倍率
+type ScoringProfile struct {
+	Success           map[string]int64            `json:"success"`
+	Endpoints         map[string]map[string]int64 `json:"endpoints,omitempty"`
+	PenaltyMultiplier map[FailCategory]float64    `json:"penalty_multiplier"`
+	// 一時的な失敗で再試行したときの1回あたりの減点
+	RetryPenalty int64 `json:"retry_penalty"`
+	// 変換された画像を読み込めたときの、変換の種類ごとの得点の倍率
+	TransformedImage map[string]float64 `json:"transformed_image"`
+
+	patterns []string
+}
+
+var (
+	scoringProfile   = DefaultScoringProfile()
+	scoringProfileMu sync.RWMutex
+)
+
+func DefaultScoringProfile() *ScoringProfile {
+	return &ScoringProfile{
+		Success: map[string]int64{
+			KindGet:    suceessGetScore,
+			KindPost:   suceessPostScore,
+			KindUpload: suceessUploadScore,
+		},
+		PenaltyMultiplier: map[FailCategory]float64{
+			FailError:     1,
+			FailException: 1,
+			FailDelay:     1,
+			FailCritical:  1,
+		},
+		RetryPenalty: retryPenaltyScore,
+		TransformedImage: map[string]float64{
+			TransformTranscoded: 1,
+			TransformResized:    0.5,
+		},
+	}
+}
+
+// JSONファイルから得点の配分を読み込む
+// 指定されていない項目はDefaultScoringProfileの値になる
+func LoadScoringProfile(file string) (*ScoringProfile, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded struct {
+		ScoringProfile
+		RetryPenalty *int64 `json:"retry_penalty"`
+	}
+	err = json.Unmarshal(data, &loaded)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	p := DefaultScoringProfile()
+	if loaded.RetryPenalty != nil {
+		if *loaded.RetryPenalty < 0 {
+			return nil, fmt.Errorf("%s: retry penalty should not be negative", file)
+		}
+		p.RetryPenalty = *loaded.RetryPenalty
+	}
+
+	for kind, w := range loaded.Success {
+		if _, ok := p.Success[kind]; !ok {
+			return nil, fmt.Errorf("%s: unknown action kind: %s", file, kind)
+		}
+		p.Success[kind] = w
+	}
+
+	if len(loaded.Endpoints) > 0 {
+		p.Endpoints = map[string]map[string]int64{}
+	}
+	for endpoint, weights := range loaded.Endpoints {
+		method, pattern, ok := strings.Cut(endpoint, " ")
+		if !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("%s: endpoint should be \"METHOD /path\": %s", file, endpoint)
+		}
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("%s: invalid endpoint pattern %s: %w", file, endpoint, err)
+		}
+		for kind := range weights {
+			if _, ok := p.Success[kind]; !ok {
+				return nil, fmt.Errorf("%s: unknown action kind for %s: %s", file, endpoint, kind)
+			}
+		}
+		p.Endpoints[endpoint] = weights
+	}
+
+	for category, m := range loaded.PenaltyMultiplier {
+		if _, ok := p.PenaltyMultiplier[category]; !ok {
+			return nil, fmt.Errorf("%s: unknown failure category: %s", file, category)
+		}
+		if m < 0 {
+			return nil, fmt.Errorf("%s: penalty multiplier should not be negative: %s", file, category)
+		}
+		p.PenaltyMultiplier[category] = m
+	}
+
+	for transformation, m := range loaded.TransformedImage {
+		if _, ok := p.TransformedImage[transformation]; !ok {
+			return nil, fmt.Errorf("%s: unknown image transformation: %s", file, transformation)
+		}
+		if m < 0 {
+			return nil, fmt.Errorf("%s: transformed image multiplier should not be negative: %s", file, transformation)
+		}
+		p.TransformedImage[transformation] = m
+	}
+
+	return p, nil
+}
+
+func SetScoringProfile(p *ScoringProfile) {
+	p.preparePatterns()
+
+	scoringProfileMu.Lock()
+	scoringProfile = p
+	scoringProfileMu.Unlock()
+}
+
+func GetScoringProfile() *ScoringProfile {
+	scoringProfileMu.RLock()
+	defer scoringProfileMu.RUnlock()
+	return scoringProfile
+}
+
+func (p *ScoringProfile) preparePatterns() {
+	p.patterns = p.patterns[:0]
+	for endpoint := range p.Endpoints {
+		p.patterns = append(p.patterns, endpoint)
+	}
+	sort.Slice(p.patterns, func(i, j int) bool {
+		if len(p.patterns[i]) != len(p.patterns[j]) {
+			return len(p.patterns[i]) > len(p.patterns[j])
+		}
+		return p.patterns[i] < p.patterns[j]
+	})
+}
+
+// 配分を正規化したJSONのSHA-256。結果にどの配分で採点したかを残すために使う
+func (p *ScoringProfile) Hash() string {
+	// mapのキーはソートされて出力されるので、同じ配分なら同じ値になる
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 成功したときの得点
+func (p *ScoringProfile) successScore(kind string, req *http.Request) int64 {
+	if req != nil {
+		if w, ok := p.Endpoints[req.Method+" "+req.URL.Path][kind]; ok {
+			return w
+		}
+		for _, pattern := range p.patterns {
+			method, pathPattern, _ := strings.Cut(pattern, " ")
+			if method != req.Method {
+				continue
+			}
+			if ok, _ := path.Match(pathPattern, req.URL.Path); !ok {
+				continue
+			}
+			if w, ok := p.Endpoints[pattern][kind]; ok {
+				return w
+			}
+		}
+	}
+
+	return p.Success[kind]
+}
+
+// 変換された画像を読み込めたときの得点
+func (p *ScoringProfile) transformedImageScore(transformation string, req *http.Request) int64 {
+	m, ok := p.TransformedImage[transformation]
+	if !ok {
+		m = 1
+	}
+	return int64(float64(p.successScore(KindGet, req))*m + 0.5)
+}
+
+// 失敗したときの減点
+func (p *ScoringProfile) failScore(category FailCategory) int64 {
+	m, ok := p.PenaltyMultiplier[category]
+	if !ok {
+		m = 1
+	}
+	return int64(float64(failBaseScores[category])*m + 0.5)
+}
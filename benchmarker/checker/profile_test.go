@@ -0,0 +1,107 @@
+package checker
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeProfile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadScoringProfile(t *testing.T) {
+	p, err := LoadScoringProfile(writeProfile(t, `{
+		"success": {"get": 3},
+		"endpoints": {
+			"GET /posts/*": {"get": 4},
+			"GET /posts/1": {"get": 5},
+			"POST /*": {"post": 6},
+			"POST /register": {"post": 10}
+		},
+		"penalty_multiplier": {"exception": 1.5}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.preparePatterns()
+
+	tests := []struct {
+		method, path, kind string
+		expected           int64
+	}{
+		{"GET", "/", KindGet, 3},
+		{"GET", "/posts/2", KindGet, 4},
+		{"GET", "/posts/1", KindGet, 5},
+		{"GET", "/posts/1/comments", KindGet, 3},
+		{"POST", "/register", KindPost, 10},
+		{"POST", "/login", KindPost, 6},
+		{"POST", "/login", KindGet, 3},
+		{"POST", "/", KindUpload, suceessUploadScore},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if got := p.successScore(tt.kind, req); got != tt.expected {
+			t.Errorf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.kind, tt.expected, got)
+		}
+	}
+
+	if got := p.failScore(FailError); got != failErrorScore {
+		t.Errorf("error: expected %d, got %d", failErrorScore, got)
+	}
+	if got := p.failScore(FailException); got != 30 {
+		t.Errorf("exception: expected 30, got %d", got)
+	}
+
+	if p.Hash() == DefaultScoringProfile().Hash() {
+		t.Error("hash should differ from default profile")
+	}
+}
+
+func TestLoadScoringProfileInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"success": {"delete": 1}}`,
+		`{"endpoints": {"/posts": {"get": 1}}}`,
+		`{"endpoints": {"GET /posts/[": {"get": 1}}}`,
+		`{"penalty_multiplier": {"timeout": 2}}`,
+		`{"penalty_multiplier": {"error": -1}}`,
+		`{"transformed_image": {"cropped": 1}}`,
+		`{"transformed_image": {"resized": -0.5}}`,
+	} {
+		if _, err := LoadScoringProfile(writeProfile(t, content)); err == nil {
+			t.Errorf("expected error for %s", content)
+		}
+	}
+}
+
+func TestScoringProfile_transformedImageScore(t *testing.T) {
+	p, err := LoadScoringProfile(writeProfile(t, `{
+		"endpoints": {"GET /image/*": {"get": 4}},
+		"transformed_image": {"transcoded": 0.5}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.preparePatterns()
+
+	req := httptest.NewRequest("GET", "/image/1.jpg", nil)
+	tests := []struct {
+		transformation string
+		expected       int64
+	}{
+		{TransformTranscoded, 2},
+		{TransformResized, 2},
+		{"unknown", 4},
+	}
+	for _, tt := range tests {
+		if got := p.transformedImageScore(tt.transformation, req); got != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.transformation, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/score"
+)
+
+// 実行したアクション1回分の結果
+// AddObserverで登録した関数に渡される
+type ActionRecord struct {
+	Time        time.Time
+	Scenario    string
+	Description string
+	Method      string
+	Path        string
+
+	// 最後に受け取ったレスポンスのステータスコード。レスポンスを受け取れなかったときは0
+	StatusCode int
+	// 受け取ったレスポンスボディのバイト数。圧縮されていれば展開する前のもの
+	Bytes int64
+	// 展開したあとのレスポンスボディのバイト数。圧縮されていなければBytesと同じ
+	DecodedBytes int64
+	// 最後に受け取ったレスポンスのContent-Encoding
+	ContentEncoding string
+
+	// アクションの開始からレスポンスを確認し終わるまでの時間
+	// PollActionでは反映を待った時間も含む
+	Latency time.Duration
+	// Latencyのうち、ネットワークの段階ごとの時間
+	Timing Timing
+
+	RequestID string
+
+	// 一時的な失敗で再試行した回数
+	Retries int
+
+	// AssetActionでキャッシュがどう使われたか。CacheHit、CacheMiss、CacheRevalidatedのいずれか
+	// それ以外のアクションでは空
+	Cache string
+
+	// このアクションで増減した得点
+	ScoreDelta int64
+	// ウォームアップ中ならtrue
+	Warmup bool
+
+	// 成功したときは空
+	Category FailCategory
+	Error    string
+}
+
+// Sessionで実行中のアクションについて記録している値
+type actionState struct {
+	// 結果の記録先。アクションを実行したctxから取り出す
+	recorder *score.Recorder
+
+	// 再試行やリダイレクトでも同じspanにする
+	traceID    string
+	spanID     string
+	requestID  string
+	statusCode int
+	bytes      int64
+	retries    int
+	scoreDelta int64
+	cache      string
+
+	contentEncoding string
+	decodedBytes    int64
+
+	timing Timing
+
+	// -artifacts-dirを指定したときだけ記録する
+	response *http.Response
+	body     artifactBody
+}
+
+// レスポンスボディを読み込んだバイト数を数える
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+type scenarioContextKey struct{}
+
+var (
+	observers   []func(ActionRecord)
+	observersMu sync.RWMutex
+)
+
+// どのシナリオのアクションかをActionRecordに記録するため、シナリオ名を持つcontextを返す
+func WithScenario(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, scenarioContextKey{}, name)
+}
+
+func scenarioFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(scenarioContextKey{}).(string)
+	return name
+}
+
+// アクションが終わるたびに呼ばれる関数を登録する
+// fは並列に呼ばれるので、すぐに返さなければならない
+func AddObserver(f func(ActionRecord)) {
+	observersMu.Lock()
+	observers = append(observers, f)
+	observersMu.Unlock()
+}
+
+// アクションの記録を始める
+// アクションごとに1つのspanを作り、再試行したリクエストにも同じspanを付ける
+func (s *Session) beginAction(ctx context.Context) time.Time {
+	s.current = actionState{recorder: score.FromContext(ctx)}
+	s.current.traceID, s.current.spanID = newSpan(ctx)
+	if s.returning {
+		s.returning = false
+		s.recorder().Current().SetReturningSession()
+	}
+	return time.Now()
+}
+
+// 結果の記録先。beginActionを呼ばずに使ったときは、どの実行にも属さない記録先になる
+func (s *Session) recorder() *score.Recorder {
+	if s.current.recorder == nil {
+		return score.FromContext(context.Background())
+	}
+	return s.current.recorder
+}
+
+func (s *Session) notify(ctx context.Context, a *Action, start time.Time, err error) {
+	observersMu.RLock()
+	defer observersMu.RUnlock()
+
+	if len(observers) == 0 {
+		return
+	}
+
+	// 中断して実行しなかった場合は記録しない
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		return
+	}
+
+	r := ActionRecord{
+		Time:            start,
+		Scenario:        scenarioFromContext(ctx),
+		Description:     a.Description,
+		Method:          a.Method,
+		Path:            a.Path,
+		StatusCode:      s.current.statusCode,
+		Bytes:           s.current.bytes,
+		DecodedBytes:    s.current.decodedBytes,
+		ContentEncoding: s.current.contentEncoding,
+		Latency:         time.Since(start),
+		Timing:          s.current.timing,
+		RequestID:       s.current.requestID,
+		Retries:         s.current.retries,
+		Cache:           s.current.cache,
+		ScoreDelta:      s.current.scoreDelta,
+		Warmup:          s.recorder().IsWarmingUp(),
+	}
+
+	if err != nil {
+		r.Category = FailException
+		r.Error = err.Error()
+
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) {
+			r.Category = reqErr.Category
+			r.RequestID = reqErr.RequestID
+		}
+	}
+
+	for _, f := range observers {
+		f(r)
+	}
+}
@@ -0,0 +1,27 @@
+package checker
+
+import (
+	"os"
+
+	"github.com/catatsuy/private-isu/benchmarker/cache"
+)
+
+// Set系の関数で設定したものとAddObserverで登録した関数を捨て、初期状態に戻す
+// 同じプロセスでベンチマークを繰り返すときに使う。アクションの実行中に呼んではいけない
+func Reset() {
+	observersMu.Lock()
+	observers = nil
+	observersMu.Unlock()
+
+	artifactsMu.Lock()
+	artifacts = nil
+	artifactsMu.Unlock()
+
+	SetDefaultRetryPolicy(nil)
+	SetScoringProfile(DefaultScoringProfile())
+	SetBrowserCache(cache.DefaultMaxBytes, 0, nil)
+	SetImageVerifyMode(ImageVerifyExact, DefaultImageHashThreshold)
+	SetErrorOutput(os.Stderr)
+
+	csrfTokenOwners.reset()
+}
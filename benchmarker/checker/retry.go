@@ -0,0 +1,171 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 再試行する失敗の種類
+const (
+	// タイムアウト
+	RetryOnTimeout = "timeout"
+	// 接続のリセットや拒否など、レスポンスを受け取る前に接続が切れた
+	RetryOnConnection = "connection"
+	// 502, 503, 504
+	RetryOnServerError = "5xx"
+)
+
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+)
+
+// 冪等なGETを一時的な失敗のときに再試行する設定
+// 再試行はGETとHEADのときだけ行い、レスポンスヘッダーを受け取るまでの失敗だけを対象にする
+type RetryPolicy struct {
+	MaxRetries int
+	// 最初の再試行までの待ち時間。再試行ごとに倍にし、MaxBackoffで打ち止めにする
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// 再試行する失敗の種類
+	On []string
+}
+
+var (
+	defaultRetryPolicy   *RetryPolicy
+	defaultRetryPolicyMu sync.RWMutex
+)
+
+// RetryPolicyを設定していないアクションで使う設定。nilなら再試行しない
+func SetDefaultRetryPolicy(p *RetryPolicy) {
+	defaultRetryPolicyMu.Lock()
+	defaultRetryPolicy = p
+	defaultRetryPolicyMu.Unlock()
+}
+
+// "timeout,connection"のようなカンマ区切りの文字列から再試行する失敗の種類を読み込む
+func ParseRetryOn(s string) ([]string, error) {
+	on := []string{}
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		switch c {
+		case "":
+			continue
+		case RetryOnTimeout, RetryOnConnection, RetryOnServerError:
+			on = append(on, c)
+		default:
+			return nil, fmt.Errorf("unknown retry class: %s", c)
+		}
+	}
+	return on, nil
+}
+
+func (a *Action) retryPolicy() *RetryPolicy {
+	if a.Method != http.MethodGet && a.Method != http.MethodHead {
+		return nil
+	}
+	if a.Retry != nil {
+		return a.Retry
+	}
+
+	defaultRetryPolicyMu.RLock()
+	defer defaultRetryPolicyMu.RUnlock()
+	return defaultRetryPolicy
+}
+
+// 再試行するべき失敗ならその種類を返す。再試行しないなら空文字列
+func (p *RetryPolicy) classify(res *http.Response, err error, expectedStatusCode int) string {
+	class := ""
+	switch {
+	case err != nil:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			class = RetryOnTimeout
+		} else if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			class = RetryOnConnection
+		}
+	case res.StatusCode != expectedStatusCode:
+		switch res.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			class = RetryOnServerError
+		}
+	}
+
+	for _, on := range p.On {
+		if on == class {
+			return class
+		}
+	}
+	return ""
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// reqを送り、RetryPolicyに従って一時的な失敗なら送り直す
+// 最後に送ったリクエストとそのレスポンスを返す
+func (s *Session) sendWithRetry(ctx context.Context, a *Action, req *http.Request) (*http.Request, *http.Response, error) {
+	res, err := s.SendRequest(req)
+
+	p := a.retryPolicy()
+	if p == nil || p.MaxRetries <= 0 {
+		return req, res, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		class := p.classify(res, err, a.ExpectedStatusCode)
+		if class == "" {
+			if attempt > 0 {
+				s.recorder().Current().SetRetryResult(true)
+			}
+			return req, res, err
+		}
+		if attempt >= p.MaxRetries {
+			s.recorder().Current().SetRetryResult(false)
+			return req, res, err
+		}
+
+		select {
+		case <-time.After(p.backoff(attempt)):
+		case <-ctx.Done():
+			// 中断したときは送り直さず、最後の結果を返す
+			return req, res, err
+		}
+
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		penalty := GetScoringProfile().RetryPenalty
+		s.current.retries++
+		s.current.scoreDelta -= penalty
+		s.recorder().Current().SetRetry(class, penalty)
+
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return req, nil, err
+			}
+		}
+		res, err = s.SendRequest(req)
+	}
+}
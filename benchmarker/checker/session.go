@@ -2,10 +2,12 @@ package checker
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/textproto"
@@ -13,9 +15,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/catatsuy/private-isu/benchmarker/score"
+	"github.com/catatsuy/private-isu/benchmarker/cache"
 )
 
 const (
@@ -23,20 +27,41 @@ const (
 )
 
 var (
-	targetHost *url.URL
+	targetHost   *url.URL
+	targetHostMu sync.RWMutex
+
+	// 最後に作ったSessionのID
+	lastSessionID uint64
+
+	// 主催者に調べてもらうためのエラーの出力先
+	errorOutput   io.Writer = os.Stderr
+	errorOutputMu sync.Mutex
 )
 
 type Session struct {
 	Client    *http.Client
 	Transport *http.Transport
 
-	logger *log.Logger
+	// CSRFトークンを発行されたセッションを区別するためのID
+	id uint64
+
+	// ログインしているはずのアカウント名。ログインしていなければ空文字列
+	accountName string
+
+	// 実行中のアクションの記録。ActionRecordを作るのに使う
+	current actionState
+
+	// この仮想ユーザーのブラウザのキャッシュ
+	cache *cache.Store
+	// 以前のキャッシュを持った状態で始めたときtrue。最初のアクションで結果に記録する
+	returning bool
 }
 
 func NewSession() *Session {
 	w := &Session{
-		logger: log.New(os.Stdout, "", 0),
+		id: atomic.AddUint64(&lastSessionID, 1),
 	}
+	w.cache, w.returning = newBrowserCache()
 
 	jar, _ := cookiejar.New(&cookiejar.Options{})
 	w.Transport = &http.Transport{}
@@ -49,14 +74,42 @@ func NewSession() *Session {
 	return w
 }
 
+// ログインするアカウント名を設定する
+// ログイン後のページで他のユーザーの情報が表示されていないかのチェックに使う
+func (s *Session) SetAccountName(accountName string) {
+	s.accountName = accountName
+}
+
 func SetTargetHost(host string) (*url.URL, error) {
 	parsedURL, err := urlParse(host)
 	if err != nil {
 		return nil, err
 	}
 
+	targetHostMu.Lock()
 	targetHost = parsedURL
-	return targetHost, nil
+	targetHostMu.Unlock()
+	return parsedURL, nil
+}
+
+func getTargetHost() *url.URL {
+	targetHostMu.RLock()
+	defer targetHostMu.RUnlock()
+	return targetHost
+}
+
+// リクエストを送れなかったときなど、主催者に調べてもらうためのエラーの出力先を設定する
+// デフォルトは標準エラー出力
+func SetErrorOutput(w io.Writer) {
+	errorOutputMu.Lock()
+	errorOutput = w
+	errorOutputMu.Unlock()
+}
+
+func logError(err error) {
+	errorOutputMu.Lock()
+	defer errorOutputMu.Unlock()
+	fmt.Fprintln(errorOutput, err)
 }
 
 func urlParse(ref string) (*url.URL, error) {
@@ -79,13 +132,14 @@ func urlParse(ref string) (*url.URL, error) {
 	}, nil
 }
 
-func (s *Session) NewRequest(method, uri string, body io.Reader) (*http.Request, error) {
+func (s *Session) NewRequest(ctx context.Context, method, uri string, body io.Reader) (*http.Request, error) {
 	parsedURL, err := url.Parse(uri)
 
 	if err != nil {
 		return nil, err
 	}
 
+	targetHost := getTargetHost()
 	if parsedURL.Scheme == "" {
 		parsedURL.Scheme = targetHost.Scheme
 	}
@@ -94,20 +148,35 @@ func (s *Session) NewRequest(method, uri string, body io.Reader) (*http.Request,
 		parsedURL.Host = targetHost.Host
 	}
 
-	req, err := http.NewRequest(method, parsedURL.String(), body)
+	req, err := http.NewRequestWithContext(detachedContext{ctx}, method, parsedURL.String(), body)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// キャッシュのVaryと比べられるように、送る前から付けておく
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+
 	return req, err
 }
 
+// 中断するときも送信済みのリクエストは打ち切らずにレスポンスを待つため、キャンセルを引き継がないcontext
+// 新しいリクエストを送らないようにするのはActionのPlayで確認する
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
 func escapeQuotes(s string) string {
 	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
 }
 
-func (s *Session) NewFileUploadRequest(uri string, params map[string]string, paramName string, asset *Asset) (*http.Request, error) {
+func (s *Session) NewFileUploadRequest(ctx context.Context, uri string, params map[string]string, paramName string, asset *Asset) (*http.Request, error) {
 	file, err := os.Open(asset.Path)
 	if err != nil {
 		return nil, err
@@ -142,15 +211,17 @@ func (s *Session) NewFileUploadRequest(uri string, params map[string]string, par
 		return nil, err
 	}
 
+	targetHost := getTargetHost()
 	parsedURL := &url.URL{
 		Scheme: targetHost.Scheme,
 		Host:   targetHost.Host,
 		Path:   uri,
 	}
 
-	req, err := http.NewRequest("POST", parsedURL.String(), body)
+	req, err := http.NewRequestWithContext(detachedContext{ctx}, "POST", parsedURL.String(), body)
 	if err == nil {
 		req.Header.Add("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Accept-Encoding", AcceptEncoding)
 	} else {
 		return nil, err
 	}
@@ -160,20 +231,99 @@ func (s *Session) NewFileUploadRequest(uri string, params map[string]string, par
 
 func (s *Session) SendRequest(req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", UserAgent)
+	// beginActionを呼ばずに送るときは、ここでspanを作る
+	if s.current.spanID == "" {
+		s.current.traceID, s.current.spanID = newSpan(req.Context())
+	}
+	setTraceHeaders(req, s.current.traceID, s.current.spanID)
+	s.current.requestID = req.Header.Get("X-Request-ID")
+
+	s.current.response = nil
+
+	req, timer := withRequestTimer(req)
+	res, err := s.Client.Do(req)
 
-	return s.Client.Do(req)
+	// 失敗したときも、接続に時間がかかっていたのかを調べられるように記録する
+	timing, firstByte := timer.snapshot()
+	s.current.timing = s.current.timing.add(timing)
+	if err != nil {
+		return res, err
+	}
+
+	s.current.statusCode = res.StatusCode
+	res.Body = &transferTimingReadCloser{ReadCloser: res.Body, firstByte: firstByte, timing: &s.current.timing}
+	res.Body = &countingReadCloser{ReadCloser: res.Body, n: &s.current.bytes}
+
+	// 展開したあとのボディで確認する。Content-Lengthは展開前のものなので消しておく
+	if ce := res.Header.Get("Content-Encoding"); ce != "" {
+		s.current.contentEncoding = ce
+		res.Body = newDecodingReadCloser(res.Body, ce)
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	}
+	res.Body = &countingReadCloser{ReadCloser: res.Body, n: &s.current.decodedBytes}
+
+	if artifactsEnabled() {
+		s.current.response = res
+		s.current.body = artifactBody{}
+		res.Body = &capturingReadCloser{ReadCloser: res.Body, body: &s.current.body}
+	}
+
+	return res, nil
+}
+
+// レスポンスボディを読み込む。読み込めなかったときはFailしたエラーを返す
+func (s *Session) readBody(req *http.Request, res *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(res.Body)
+	if err == nil {
+		return body, nil
+	}
+
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		return nil, s.Fail(FailError, res.Request, fmt.Errorf("Content-Encoding: %s のレスポンスを展開できませんでした", decodeErr.Encoding))
+	}
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return nil, s.Fail(FailException, req, errors.New("リクエストがタイムアウトしました"))
+	}
+	logError(err)
+	return nil, s.Fail(FailException, req, errors.New("レスポンスの読み込みに失敗しました"))
+}
+
+func (s *Session) Success(kind string, req *http.Request) {
+	point := GetScoringProfile().successScore(kind, req)
+	s.current.scoreDelta += point
+	s.recorder().Current().SetScore(point)
 }
 
-func (s *Session) Success(point int64) {
-	score.GetInstance().SetScore(point)
+// 静的ファイルや画像を読み込めたときの得点
+// 変換された画像なら、変換の種類に応じた倍率をかける
+func (s *Session) successAsset(transformation string, req *http.Request) {
+	if transformation == "" {
+		s.Success(KindGet, req)
+		return
+	}
+
+	point := GetScoringProfile().transformedImageScore(transformation, req)
+	s.current.scoreDelta += point
+	s.recorder().Current().SetScore(point)
 }
 
-func (s *Session) Fail(point int64, req *http.Request, err error) error {
-	score.GetInstance().SetFails(point)
+func (s *Session) Fail(category FailCategory, req *http.Request, err error) error {
+	point := GetScoringProfile().failScore(category)
+	s.current.scoreDelta -= point
+	if category == FailCritical {
+		s.recorder().Current().SetCriticalFails(point)
+	} else {
+		s.recorder().Current().SetFails(point)
+	}
 	if req != nil {
-		err = fmt.Errorf("%s (%s %s)", err, req.Method, req.URL.Path)
+		reqErr := newRequestError(req, category, err)
+		s.saveArtifact(category, req, reqErr)
+		err = reqErr
 	}
 
-	score.GetFailErrorsInstance().Append(err)
+	s.recorder().CurrentFailErrors().Append(err)
 	return err
 }
@@ -0,0 +1,145 @@
+package checker
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// アクションで送ったリクエストの、ネットワークの段階ごとの所要時間の合計
+// リダイレクトした場合はリダイレクト先へのリクエストも含む
+type Timing struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// リクエストを送り終えてから、レスポンスの最初の1バイトを受け取るまで。アプリケーションの処理時間
+	TTFB time.Duration
+	// レスポンスの最初の1バイトを受け取ってから、ボディを読み終えるまで
+	Transfer time.Duration
+
+	// 新しく接続したリクエストの数。接続を使い回したリクエストにはDNS、Connect、TLSの時間はかからない
+	NewConnections int
+}
+
+// httptraceのコールバックは別のgoroutineから呼ばれることがあるので、ロックして記録する
+type requestTimer struct {
+	mu sync.Mutex
+	Timing
+
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+}
+
+func (t *requestTimer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if !info.Reused {
+				t.NewConnections++
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.DNS += time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.Connect += time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.TLS += time.Since(t.tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+			if !t.wroteRequest.IsZero() {
+				t.TTFB += t.firstByte.Sub(t.wroteRequest)
+			}
+		},
+	}
+}
+
+// 接続を待っている間に始めた別の接続のコールバックが後から呼ばれることがあるので、
+// レスポンスを受け取った時点のものを返す
+func (t *requestTimer) snapshot() (Timing, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Timing, t.firstByte
+}
+
+func (t Timing) add(o Timing) Timing {
+	return Timing{
+		DNS:            t.DNS + o.DNS,
+		Connect:        t.Connect + o.Connect,
+		TLS:            t.TLS + o.TLS,
+		TTFB:           t.TTFB + o.TTFB,
+		Transfer:       t.Transfer + o.Transfer,
+		NewConnections: t.NewConnections + o.NewConnections,
+	}
+}
+
+func withRequestTimer(req *http.Request) (*http.Request, *requestTimer) {
+	t := &requestTimer{}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace())), t
+}
+
+// ボディを読み終えるか閉じたときに、最初の1バイトからの時間を記録する
+type transferTimingReadCloser struct {
+	io.ReadCloser
+	firstByte time.Time
+	timing    *Timing
+	done      bool
+}
+
+func (r *transferTimingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *transferTimingReadCloser) Close() error {
+	r.finish()
+	return r.ReadCloser.Close()
+}
+
+func (r *transferTimingReadCloser) finish() {
+	if r.done || r.firstByte.IsZero() {
+		return
+	}
+	r.done = true
+	r.timing.Transfer += time.Since(r.firstByte)
+}
@@ -0,0 +1,50 @@
+package checker
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendRequest_timing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession()
+	for i, newConnections := range []int{1, 0} {
+		s.beginAction(context.Background())
+		req, err := s.NewRequest(context.Background(), "GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.SendRequest(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.readBody(req, res); err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		timing := s.current.timing
+		if timing.NewConnections != newConnections {
+			t.Errorf("request %d: expected %d new connections, got %d", i, newConnections, timing.NewConnections)
+		}
+		if newConnections > 0 && timing.Connect <= 0 {
+			t.Errorf("request %d: connect time should be recorded for a new connection", i)
+		}
+		if timing.TTFB < 20*time.Millisecond {
+			t.Errorf("request %d: ttfb should include the server time, got %s", i, timing.TTFB)
+		}
+	}
+}
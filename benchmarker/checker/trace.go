@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+)
+
+// webappのログとベンチマーカーの失敗を突き合わせるため、リクエストにW3C Trace ContextのtraceparentとX-Request-IDを付ける
+// シナリオを1回実行するごとに1つのtrace、アクションごとに1つのspanにする
+
+type traceContextKey struct{}
+
+// シナリオの実行ごとに呼び、新しいtrace IDを持つcontextを返す
+func NewTraceContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, randomHex(16))
+}
+
+func traceIDFromContext(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceContextKey{}).(string)
+	return traceID
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ctxのtraceに新しいspanを作る。ctxにtraceがなければ新しく作る
+func newSpan(ctx context.Context) (traceID, spanID string) {
+	traceID = traceIDFromContext(ctx)
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	return traceID, randomHex(8)
+}
+
+func setTraceHeaders(req *http.Request, traceID, spanID string) {
+	req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, spanID))
+	req.Header.Set("X-Request-ID", traceID+"-"+spanID)
+}
+
+// リクエストに失敗したときのエラー
+// Error()にはリクエストIDを含めないので、同じ失敗はまとめて表示される
+type RequestError struct {
+	Err       error
+	Category  FailCategory
+	Method    string
+	Path      string
+	RequestID string
+}
+
+func newRequestError(req *http.Request, category FailCategory, err error) *RequestError {
+	return &RequestError{
+		Err:       err,
+		Category:  category,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		RequestID: req.Header.Get("X-Request-ID"),
+	}
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s (%s %s)", e.Err, e.Method, e.Path)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// traceparentのtrace IDの部分
+func (e *RequestError) TraceID() string {
+	if len(e.RequestID) < 32 {
+		return ""
+	}
+	return e.RequestID[:32]
+}
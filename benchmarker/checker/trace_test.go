@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTraceHeaders(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		traceparent []string
+		requestIDs  []string
+		failed      = map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		traceparent = append(traceparent, r.Header.Get("traceparent"))
+		requestIDs = append(requestIDs, r.Header.Get("X-Request-ID"))
+
+		// 最初のリクエストだけ失敗させて再試行させる
+		if !failed[r.URL.Path] {
+			failed[r.URL.Path] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}))
+	defer ts.Close()
+
+	if _, err := SetTargetHost(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewTraceContext(context.Background())
+	s := NewSession()
+	for _, path := range []string{"/a", "/b"} {
+		a := NewAction("GET", path)
+		a.Retry = &RetryPolicy{MaxRetries: 1, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, On: []string{RetryOnServerError}}
+		if err := a.Play(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(traceparent) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(traceparent))
+	}
+
+	// 00-<trace ID>-<span ID>-01
+	parts := make([][]string, len(traceparent))
+	for i, h := range traceparent {
+		parts[i] = strings.Split(h, "-")
+		if len(parts[i]) != 4 || len(parts[i][1]) != 32 || len(parts[i][2]) != 16 {
+			t.Fatalf("invalid traceparent: %q", h)
+		}
+		if requestIDs[i] != parts[i][1]+"-"+parts[i][2] {
+			t.Errorf("X-Request-ID %q does not match traceparent %q", requestIDs[i], h)
+		}
+	}
+
+	for i := range parts {
+		if parts[i][1] != traceIDFromContext(ctx) {
+			t.Errorf("request %d: expected trace ID of the scenario, got %s", i, parts[i][1])
+		}
+	}
+	if parts[0][2] != parts[1][2] || parts[2][2] != parts[3][2] {
+		t.Errorf("retries should share the span: %v", traceparent)
+	}
+	if parts[0][2] == parts[2][2] {
+		t.Errorf("actions should have different spans: %v", traceparent)
+	}
+}
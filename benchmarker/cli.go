@@ -1,30 +1,23 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/catatsuy/private-isu/benchmarker/bench"
 	"github.com/catatsuy/private-isu/benchmarker/checker"
-	"github.com/catatsuy/private-isu/benchmarker/score"
-	"github.com/catatsuy/private-isu/benchmarker/util"
 )
 
 // Exit codes are int values that represent an exit code for a particular error.
 const (
 	ExitCodeOK    int = 0
 	ExitCodeError int = 1 + iota
-
-	FailThreshold     = 5
-	InitializeTimeout = time.Duration(10) * time.Second
-	BenchmarkTimeout  = 60 * time.Second
-	WaitAfterTimeout  = 10 * time.Second
-
-	PostsPerPage = 20
 )
 
 // CLI is the command line object
@@ -34,49 +27,80 @@ type CLI struct {
 	outStream, errStream io.Writer
 }
 
-type user struct {
-	AccountName string
-	Password    string
-}
-
-type Output struct {
-	Pass     bool     `json:"pass"`
-	Score    int64    `json:"score"`
-	Suceess  int64    `json:"success"`
-	Fail     int64    `json:"fail"`
-	Messages []string `json:"messages"`
-}
-
 // Run invokes the CLI with the given arguments.
 func (cli *CLI) Run(args []string) int {
+	if len(args) > 1 && args[1] == GenerateUserdataCommand {
+		return cli.runGenerateUserdata(args[1:])
+	}
+	if len(args) > 1 && args[1] == GenerateAssetManifestCommand {
+		return cli.runGenerateAssetManifest(args[1:])
+	}
+
+	c := bench.DefaultConfig()
+
 	var (
-		target   string
-		userdata string
+		scoringProfile string
 
-		benchmarkTimeout time.Duration
-		waitAfterTimeout time.Duration
+		retries      int
+		retryBackoff time.Duration
+		retryOn      string
+
+		showDashboard bool
+		events        string
 
 		version bool
-		debug   bool
 	)
 
 	// Define option flag parse
 	flags := flag.NewFlagSet(Name, flag.ContinueOnError)
 	flags.SetOutput(cli.errStream)
 
-	flags.StringVar(&target, "target", "", "")
-	flags.StringVar(&target, "t", "", "(Short)")
+	flags.StringVar(&c.Target, "target", "", "")
+	flags.StringVar(&c.Target, "t", "", "(Short)")
+
+	flags.StringVar(&c.Userdata, "userdata", "", "userdata directory")
+	flags.StringVar(&c.Userdata, "u", "", "userdata directory")
+
+	flags.DurationVar(&c.BenchmarkTimeout, "benchmark-timeout", c.BenchmarkTimeout, "benchmark timeout")
+	flags.DurationVar(&c.WaitAfterTimeout, "wait-after-timeout", c.WaitAfterTimeout, "wait after timeout")
+	flags.DurationVar(&c.Warmup, "warmup", c.Warmup, "warm-up duration excluded from score")
+	flags.DurationVar(&c.DrainTimeout, "drain-timeout", c.DrainTimeout, "wait for in-flight requests after interrupted")
+
+	flags.DurationVar(&c.FreshnessWindow, "freshness-window", c.FreshnessWindow, "new posts should appear on index and pagination within this duration")
+
+	flags.IntVar(&c.AuditSamples, "audit-samples", c.AuditSamples, "number of created users and posts to verify after benchmark (0 disables audit)")
+
+	flags.Int64Var(&c.Rules.MaxFails, "max-fails", c.Rules.MaxFails, "disqualify if failures exceed this count (0 disables)")
+	flags.Float64Var(&c.Rules.MaxFailRatio, "max-fail-ratio", c.Rules.MaxFailRatio, "disqualify if ratio of failures to all requests exceeds this (1 disables)")
+	flags.BoolVar(&c.Rules.AllowCritical, "allow-critical", c.Rules.AllowCritical, "do not disqualify on critical failures such as leaked user data or CSRF bypass")
+	flags.Int64Var(&c.Rules.MinSuccess, "min-success", c.Rules.MinSuccess, "disqualify if successful requests are fewer than this (0 disables)")
 
-	flags.StringVar(&userdata, "userdata", "", "userdata directory")
-	flags.StringVar(&userdata, "u", "", "userdata directory")
+	flags.StringVar(&scoringProfile, "scoring-profile", "", "scoring profile JSON file (weights per action kind and endpoint, penalty multipliers per failure category)")
 
-	flags.DurationVar(&benchmarkTimeout, "benchmark-timeout", BenchmarkTimeout, "benchmark timeout")
-	flags.DurationVar(&waitAfterTimeout, "wait-after-timeout", WaitAfterTimeout, "wait after timeout")
+	flags.StringVar(&c.ImageVerify, "image-verify", c.ImageVerify, "image verify mode (exact or perceptual)")
+	flags.IntVar(&c.ImageHashThreshold, "image-hash-threshold", c.ImageHashThreshold, "max hamming distance of perceptual hash in perceptual image verify mode")
+
+	flags.StringVar(&c.LoadModel, "load-model", c.LoadModel, "load model (closed or open)")
+	flags.Float64Var(&c.OpenLoop.Rate, "arrival-rate", c.OpenLoop.Rate, "scenario iterations started per second in open load model")
+	flags.StringVar(&c.OpenLoop.Arrival, "arrival", c.OpenLoop.Arrival, "arrival process in open load model (constant or poisson)")
+	flags.IntVar(&c.OpenLoop.MaxInflight, "max-inflight", c.OpenLoop.MaxInflight, "max running scenario iterations in open load model (0 means unlimited)")
+
+	flags.IntVar(&retries, "retries", 0, "max retries of GET requests on transient failures (0 disables retry)")
+	flags.DurationVar(&retryBackoff, "retry-backoff", checker.DefaultRetryBackoff, "wait before first retry, doubled for each retry")
+	flags.StringVar(&retryOn, "retry-on", checker.RetryOnTimeout+","+checker.RetryOnConnection, "comma separated failures to retry (timeout, connection, 5xx)")
+
+	flags.Int64Var(&c.CacheSize, "cache-size", c.CacheSize, "max bytes of HTTP cache per virtual user (least recently used entries are evicted)")
+	flags.Float64Var(&c.ReturningUsers, "returning-users", c.ReturningUsers, "ratio of virtual users starting with a cache of previous visits (0 to 1)")
+	flags.StringVar(&c.CacheFile, "cache-file", c.CacheFile, "load the cache for returning users from this file and save it after benchmark")
+
+	flags.BoolVar(&showDashboard, "dashboard", false, "show live progress on stderr during benchmark")
+	flags.StringVar(&events, "events", "", "write each completed action as JSON Lines to this file (- for stdout, then the result is printed to stderr)")
+	flags.StringVar(&c.ArtifactsDir, "artifacts-dir", c.ArtifactsDir, "save failing requests and responses to this directory with index.json")
 
 	flags.BoolVar(&version, "version", false, "Print version information and quit.")
 
-	flags.BoolVar(&debug, "debug", false, "Debug mode")
-	flags.BoolVar(&debug, "d", false, "Debug mode")
+	flags.BoolVar(&c.Debug, "debug", false, "Debug mode")
+	flags.BoolVar(&c.Debug, "d", false, "Debug mode")
 
 	// Parse commandline flag
 	if err := flags.Parse(args[1:]); err != nil {
@@ -89,180 +113,66 @@ func (cli *CLI) Run(args []string) int {
 		return ExitCodeOK
 	}
 
-	targetHost, err := checker.SetTargetHost(target)
-	if err != nil {
-		outputNeedToContactUs(err.Error())
-		return ExitCodeError
+	// 結果はJSON Linesと形式が違うので、イベントを標準出力に書き出すときは標準エラー出力に出す
+	resultStream := cli.outStream
+	if events == "-" {
+		resultStream = cli.errStream
 	}
 
-	initialize := make(chan bool)
-
-	setupInitialize(targetHost, initialize)
-
-	users, _, adminUsers, sentences, images, err := prepareUserdata(userdata)
-	if err != nil {
-		outputNeedToContactUs(err.Error())
-		return ExitCodeError
+	if scoringProfile != "" {
+		p, err := checker.LoadScoringProfile(scoringProfile)
+		if err != nil {
+			outputNeedToContactUs(resultStream, err.Error())
+			return ExitCodeError
+		}
+		c.ScoringProfile = p
 	}
 
-	initReq := <-initialize
-
-	if !initReq {
-		fmt.Println(outputResultJSON(false, []string{"初期化リクエストに失敗しました"}))
-
-		return ExitCodeError
+	if retries != 0 {
+		on, err := checker.ParseRetryOn(retryOn)
+		if err != nil {
+			outputNeedToContactUs(resultStream, err.Error())
+			return ExitCodeError
+		}
+		c.Retry = &checker.RetryPolicy{
+			MaxRetries: retries,
+			Backoff:    retryBackoff,
+			MaxBackoff: checker.DefaultRetryMaxBackoff,
+			On:         on,
+		}
 	}
 
-	// 最初にDOMチェックなどをやってしまい、通らなければさっさと失敗させる
-	commentScenario(checker.NewSession(), randomUser(users), randomUser(users).AccountName, randomSentence(sentences))
-	postImageScenario(checker.NewSession(), randomUser(users), randomImage(images), randomSentence(sentences))
-	cannotLoginNonexistentUserScenario(checker.NewSession())
-	cannotLoginWrongPasswordScenario(checker.NewSession(), randomUser(users))
-	cannotAccessAdminScenario(checker.NewSession(), randomUser(users))
-	cannotPostWrongCSRFTokenScenario(checker.NewSession(), randomUser(users), randomImage(images))
-	loginScenario(checker.NewSession(), randomUser(users))
-	banScenario(checker.NewSession(), checker.NewSession(), randomUser(users), randomUser(adminUsers), randomImage(images), randomSentence(sentences))
-
-	if score.GetInstance().GetFails() > 0 {
-		fmt.Println(outputResultJSON(false, score.GetFailErrorsStringSlice()))
-		return ExitCodeError
+	if showDashboard {
+		c.Dashboard = cli.errStream
 	}
+	c.ErrorOutput = cli.errStream
 
-	indexMoreAndMoreScenarioCh := makeChanBool(2)
-	loadIndexScenarioCh := makeChanBool(2)
-	userAndPostPageScenarioCh := makeChanBool(2)
-	commentScenarioCh := makeChanBool(1)
-	postImageScenarioCh := makeChanBool(1)
-	loginScenarioCh := makeChanBool(2)
-	banScenarioCh := makeChanBool(1)
-
-	timeoutCh := time.After(benchmarkTimeout)
-
-L:
-	for {
-		select {
-		case <-indexMoreAndMoreScenarioCh:
-			go func() {
-				indexMoreAndMoreScenario(checker.NewSession())
-				indexMoreAndMoreScenarioCh <- true
-			}()
-		case <-loadIndexScenarioCh:
-			go func() {
-				loadIndexScenario(checker.NewSession())
-				loadIndexScenarioCh <- true
-			}()
-		case <-userAndPostPageScenarioCh:
-			go func() {
-				userAndPostPageScenario(checker.NewSession(), randomUser(users).AccountName)
-				userAndPostPageScenarioCh <- true
-			}()
-		case <-commentScenarioCh:
-			go func() {
-				commentScenario(checker.NewSession(), randomUser(users), randomUser(users).AccountName, randomSentence(sentences))
-				commentScenarioCh <- true
-			}()
-		case <-postImageScenarioCh:
-			go func() {
-				postImageScenario(checker.NewSession(), randomUser(users), randomImage(images), randomSentence(sentences))
-				cannotPostWrongCSRFTokenScenario(checker.NewSession(), randomUser(users), randomImage(images))
-				postImageScenarioCh <- true
-			}()
-		case <-loginScenarioCh:
-			go func() {
-				loginScenario(checker.NewSession(), randomUser(users))
-				cannotLoginNonexistentUserScenario(checker.NewSession())
-				cannotLoginWrongPasswordScenario(checker.NewSession(), randomUser(users))
-				loginScenarioCh <- true
-			}()
-		case <-banScenarioCh:
-			go func() {
-				banScenario(checker.NewSession(), checker.NewSession(), randomUser(users), randomUser(adminUsers), randomImage(images), randomSentence(sentences))
-				cannotAccessAdminScenario(checker.NewSession(), randomUser(users))
-				banScenarioCh <- true
-			}()
-		case <-timeoutCh:
-			break L
+	if events == "-" {
+		c.Events = cli.outStream
+	} else if events != "" {
+		f, err := os.Create(events)
+		if err != nil {
+			outputNeedToContactUs(resultStream, err.Error())
+			return ExitCodeError
 		}
+		defer f.Close()
+		c.Events = f
 	}
 
-	time.Sleep(waitAfterTimeout)
-
-	var msgs []string
-	if !debug {
-		msgs = score.GetFailErrorsStringSlice()
-	} else {
-		msgs = score.GetFailRawErrorsStringSlice()
-	}
-
-	fmt.Println(outputResultJSON(true, msgs))
+	// SIGINTやSIGTERMを受け取ったら新しいリクエストを送るのをやめ、途中までの結果を出力する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	return ExitCodeOK
-}
+	result, err := bench.Run(ctx, c)
+	fmt.Fprintln(resultStream, result.JSON())
 
-func outputResultJSON(pass bool, messages []string) string {
-	output := Output{
-		Pass:     pass,
-		Score:    score.GetInstance().GetScore(),
-		Suceess:  score.GetInstance().GetSucesses(),
-		Fail:     score.GetInstance().GetFails(),
-		Messages: messages,
+	if err != nil || !result.Pass {
+		return ExitCodeError
 	}
-
-	b, _ := json.Marshal(output)
-
-	return string(b)
+	return ExitCodeOK
 }
 
 // 主催者に連絡して欲しいエラー
-func outputNeedToContactUs(message string) {
-	fmt.Println(outputResultJSON(false, []string{"！！！主催者に連絡してください！！！", message}))
-}
-
-func makeChanBool(len int) chan bool {
-	ch := make(chan bool, len)
-	for i := 0; i < len; i++ {
-		ch <- true
-	}
-	return ch
-}
-
-func randomUser(users []user) user {
-	return users[util.RandomNumber(len(users))]
-}
-
-func randomImage(images []*checker.Asset) *checker.Asset {
-	return images[util.RandomNumber(len(images))]
-}
-
-func randomSentence(sentences []string) string {
-	return sentences[util.RandomNumber(len(sentences))]
-}
-
-func setupInitialize(targetHost *url.URL, initialize chan bool) {
-	go func(targetHost *url.URL) {
-		client := &http.Client{
-			Timeout: InitializeTimeout,
-		}
-
-		parsedURL := &url.URL{
-			Scheme: targetHost.Scheme,
-			Host:   targetHost.Host,
-			Path:   "/initialize",
-		}
-		req, err := http.NewRequest("GET", parsedURL.String(), nil)
-		if err != nil {
-			return
-		}
-
-		req.Header.Set("User-Agent", checker.UserAgent)
-
-		res, err := client.Do(req)
-
-		if err != nil {
-			initialize <- false
-			return
-		}
-		defer res.Body.Close()
-		initialize <- true
-	}(targetHost)
+func outputNeedToContactUs(w io.Writer, message string) {
+	fmt.Fprintln(w, bench.NeedToContactUs(message).JSON())
 }
@@ -31,3 +31,41 @@ func TestRun_targetFlag(t *testing.T) {
 	status := cli.Run(args)
 	_ = status
 }
+
+func TestRun_eventsToStdout(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("./benchmarker -t http://localhost:1 -u "+t.TempDir()+"/nonexistent -events -", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeError {
+		t.Errorf("expected %d to eq %d", status, ExitCodeError)
+	}
+
+	// 標準出力にはイベントのJSON Linesだけを書き出す
+	if outStream.Len() != 0 {
+		t.Errorf("expected no result on stdout, got %q", outStream.String())
+	}
+	if !strings.Contains(errStream.String(), `"pass":false`) {
+		t.Errorf("expected the result on stderr, got %q", errStream.String())
+	}
+}
+
+func TestRun_eventsToStdoutContactUs(t *testing.T) {
+	outStream, errStream := new(bytes.Buffer), new(bytes.Buffer)
+	cli := &CLI{outStream: outStream, errStream: errStream}
+	args := strings.Split("./benchmarker -retries 1 -retry-on unknown -events -", " ")
+
+	status := cli.Run(args)
+	if status != ExitCodeError {
+		t.Errorf("expected %d to eq %d", status, ExitCodeError)
+	}
+
+	// 主催者に連絡して欲しいエラーも結果と同じく標準エラー出力に出す
+	if outStream.Len() != 0 {
+		t.Errorf("expected no result on stdout, got %q", outStream.String())
+	}
+	if !strings.Contains(errStream.String(), `"pass":false`) {
+		t.Errorf("expected the result on stderr, got %q", errStream.String())
+	}
+}
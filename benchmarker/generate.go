@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/catatsuy/private-isu/benchmarker/bench"
+)
+
+//go:embed sql/schema.sql
+var schemaSQL string
+
+const (
+	GenerateUserdataCommand = "generate-userdata"
+
+	// SQLに含めるINSERTを何行ずつまとめるか
+	generateInsertBatchSize = 100
+
+	// webappの/initializeはこれより大きいidの行を消すので、これを超えて生成しない
+	maxGeneratedUsers    = 1000
+	maxGeneratedPosts    = 10000
+	maxGeneratedComments = 100000
+
+	// indexMoreAndMoreScenarioは最新の投稿からPostsPerPage秒ずつ10ページ遡って「もっと見る」を読み込む
+	// 投稿は1秒おきに作るので11ページ分と、banされたユーザーの投稿で表示されない分の余裕が必要
+	minGeneratedPosts = bench.PostsPerPage*11 + 30
+)
+
+var (
+	nameSyllables = []string{
+		"a", "ka", "sa", "ta", "na", "ha", "ma", "ya", "ra", "wa",
+		"i", "ki", "shi", "chi", "ni", "hi", "mi", "ri",
+		"u", "ku", "su", "tsu", "nu", "fu", "mu", "yu", "ru",
+		"e", "ke", "se", "te", "ne", "he", "me", "re",
+		"o", "ko", "so", "to", "no", "ho", "mo", "yo", "ro",
+		"ly", "ette", "son", "ine",
+	}
+
+	kaomojiFaces = []string{
+		"(*ﾟｰﾟ)", "('-'*)", "(・∀・)", "(´・ω・`)", "(＾∇＾)", "ヾ(＾∇＾)", "(ﾟ∀ﾟ)", "(｀・ω・´)",
+		"(^_^)", "(o^^o)", "(≧▽≦)", "(*´▽｀*)", "(・ω・)ノ", "(￣ー￣)", "(ノ´∀｀*)", "(=ﾟωﾟ)ﾉ",
+	}
+	kaomojiWords = []string{
+		"おはよー", "ｵﾊﾖ", "こんにちは", "こんばんは", "おやすみ", "ただいま", "おかえり", "よろしく",
+		"ありがとう", "ラーメン", "いただきます", "ごちそうさま", "はじめまして", "またね",
+	}
+	kaomojiSuffixes = []string{"", "♪", "！", "～", "☆", "♪♪", "…", "!!"}
+)
+
+// オフライン環境用にuserdataと初期データのSQLを生成する
+// 同じseedと引数からは常に同じものが生成される
+func (cli *CLI) runGenerateUserdata(args []string) int {
+	var (
+		output string
+
+		users     int
+		sentences int
+		images    int
+		comments  int
+		width     int
+		height    int
+		seed      int64
+	)
+
+	flags := flag.NewFlagSet(GenerateUserdataCommand, flag.ContinueOnError)
+	flags.SetOutput(cli.errStream)
+
+	flags.StringVar(&output, "output", "", "output directory")
+	flags.StringVar(&output, "o", "", "output directory (Short)")
+
+	flags.IntVar(&users, "users", 1000, "number of users")
+	flags.IntVar(&sentences, "sentences", 1000, "number of sentences")
+	flags.IntVar(&images, "images", 500, "number of images (each image is posted once)")
+	flags.IntVar(&comments, "comments", 5000, "number of comments")
+	flags.IntVar(&width, "width", 200, "image width")
+	flags.IntVar(&height, "height", 150, "image height")
+	flags.Int64Var(&seed, "seed", 1, "random seed")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return ExitCodeError
+	}
+
+	if output == "" {
+		fmt.Fprintln(cli.errStream, "output directory is required")
+		return ExitCodeError
+	}
+
+	err := validateGenerateOptions(users, sentences, images, comments, width, height)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+
+	err = generateUserdata(output, users, sentences, images, comments, width, height, seed)
+	if err != nil {
+		fmt.Fprintln(cli.errStream, err)
+		return ExitCodeError
+	}
+
+	return ExitCodeOK
+}
+
+func validateGenerateOptions(users, sentences, images, comments, width, height int) error {
+	// adminの9人とbanされるユーザーを除いても通常ユーザーが残る必要がある
+	if users < 50 || users > maxGeneratedUsers {
+		return fmt.Errorf("users must be between 50 and %d, got %d", maxGeneratedUsers, users)
+	}
+	// インデックスページに1ページ分の投稿が必要
+	if images < minGeneratedPosts || images > maxGeneratedPosts {
+		return fmt.Errorf("images must be between %d and %d, got %d", minGeneratedPosts, maxGeneratedPosts, images)
+	}
+	if comments < 0 || comments > maxGeneratedComments {
+		return fmt.Errorf("comments must be between 0 and %d, got %d", maxGeneratedComments, comments)
+	}
+	if sentences < 1 {
+		return fmt.Errorf("sentences must be >= 1, got %d", sentences)
+	}
+	if width < 8 || height < 8 {
+		return fmt.Errorf("width and height must be >= 8, got %dx%d", width, height)
+	}
+	return nil
+}
+
+func generateUserdata(output string, users, sentences, images, comments, width, height int, seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+
+	err := os.MkdirAll(filepath.Join(output, "img"), 0755)
+	if err != nil {
+		return err
+	}
+
+	names := generateNames(r, users)
+	err = writeLines(filepath.Join(output, "names.txt"), names)
+	if err != nil {
+		return err
+	}
+
+	err = writeManifest(filepath.Join(output, "manifest.json"), names)
+	if err != nil {
+		return err
+	}
+
+	kaomoji := generateSentences(r, sentences)
+	err = writeLines(filepath.Join(output, "kaomoji.txt"), kaomoji)
+	if err != nil {
+		return err
+	}
+
+	sqlFile, err := os.Create(filepath.Join(output, "seed.sql"))
+	if err != nil {
+		return err
+	}
+	defer sqlFile.Close()
+
+	w := bufio.NewWriter(sqlFile)
+
+	fmt.Fprintln(w, "DROP DATABASE IF EXISTS `isuconp`;")
+	fmt.Fprintln(w, "CREATE DATABASE `isuconp` DEFAULT CHARACTER SET utf8mb4;")
+	fmt.Fprintln(w, "USE `isuconp`;")
+	fmt.Fprintln(w, schemaSQL)
+
+	writeUsersSQL(w, names)
+
+	err = writePostsSQL(w, r, output, len(names), kaomoji, images, width, height)
+	if err != nil {
+		return err
+	}
+
+	writeCommentsSQL(w, r, len(names), images, kaomoji, comments)
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	return sqlFile.Close()
+}
+
+// アカウント名として使える [0-9a-zA-Z_] だけの重複しない名前を作る
+func generateNames(r *rand.Rand, n int) []string {
+	names := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+
+	for len(names) < n {
+		var sb strings.Builder
+		syllables := 2 + r.Intn(3)
+		for i := 0; i < syllables; i++ {
+			sb.WriteString(nameSyllables[r.Intn(len(nameSyllables))])
+		}
+		name := sb.String()
+		if len(name) < 3 || seen[name] {
+			name = fmt.Sprintf("%s%d", name, len(names))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func generateSentences(r *rand.Rand, n int) []string {
+	sentences := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		face := kaomojiFaces[r.Intn(len(kaomojiFaces))]
+		word := kaomojiWords[r.Intn(len(kaomojiWords))]
+		suffix := kaomojiSuffixes[r.Intn(len(kaomojiSuffixes))]
+		if r.Intn(2) == 0 {
+			sentences = append(sentences, face+word+suffix)
+		} else {
+			sentences = append(sentences, word+suffix+face)
+		}
+	}
+	return sentences
+}
+
+func writeLines(path string, lines []string) error {
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// names.txt と同じ内容を役割つきで書き出す
+func writeManifest(path string, names []string) error {
+	manifest := bench.UserManifest{Users: make([]bench.UserManifestEntry, 0, len(names))}
+	for i, name := range names {
+		manifest.Users = append(manifest.Users, bench.UserManifestEntry{
+			AccountName: name,
+			Password:    name + name,
+			Role:        bench.PositionalRole(i + 1),
+		})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// グラデーションの背景に円と矩形を適当に描いた画像を作る
+func generateImage(r *rand.Rand, width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	from := color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+	to := color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			t := float64(x+y) / float64(width+height)
+			img.Set(x, y, color.RGBA{
+				uint8(float64(from.R)*(1-t) + float64(to.R)*t),
+				uint8(float64(from.G)*(1-t) + float64(to.G)*t),
+				uint8(float64(from.B)*(1-t) + float64(to.B)*t),
+				255,
+			})
+		}
+	}
+
+	shapes := 3 + r.Intn(5)
+	for i := 0; i < shapes; i++ {
+		c := color.RGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+		cx, cy := r.Intn(width), r.Intn(height)
+		size := 1 + r.Intn((width+height)/6+1)
+		circle := r.Intn(2) == 0
+
+		for y := cy - size; y <= cy+size; y++ {
+			for x := cx - size; x <= cx+size; x++ {
+				if circle && (x-cx)*(x-cx)+(y-cy)*(y-cy) > size*size {
+					continue
+				}
+				if (image.Point{x, y}).In(img.Rect) {
+					img.Set(x, y, c)
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+// jpg, png, gifの順に画像の形式を変える
+func encodeGeneratedImage(i int, img image.Image) ([]byte, string, string, error) {
+	buf := &bytes.Buffer{}
+	var err error
+
+	switch i % 3 {
+	case 1:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "jpg", "image/jpeg", err
+	case 2:
+		err = png.Encode(buf, img)
+		return buf.Bytes(), "png", "image/png", err
+	default:
+		err = gif.Encode(buf, img, nil)
+		return buf.Bytes(), "gif", "image/gif", err
+	}
+}
+
+// userdata/load.rb と同じく、パスワードは名前を2回繰り返したもので、役割は行番号から決める
+func writeUsersSQL(w io.Writer, names []string) {
+	base := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for start := 0; start < len(names); start += generateInsertBatchSize {
+		fmt.Fprint(w, "INSERT INTO `users` (`id`,`account_name`,`passhash`,`authority`,`del_flg`,`created_at`) VALUES ")
+		for j := start; j < len(names) && j < start+generateInsertBatchSize; j++ {
+			i := j + 1
+			name := names[j]
+
+			authority := 0
+			delFlg := 0
+			switch bench.PositionalRole(i) {
+			case bench.RoleAdmin:
+				authority = 1
+			case bench.RoleBanned:
+				delFlg = 1
+			}
+
+			if j > start {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, "(%d,'%s','%s',%d,%d,'%s')",
+				i, name, calculatePasshash(name, name+name), authority, delFlg,
+				base.Add(time.Duration(i)*time.Second).Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintln(w, ";")
+	}
+}
+
+// 投稿の作成日時はベンチマーカーの「もっと見る」が辿れるように、最新の投稿がload.rbで1万件入れたときと同じになるようにする
+// load.rbは実行した環境のタイムゾーンで書き込むが、同じseedから同じSQLを生成するためにUTCで書き込む
+// load.rbをTZ=UTCで実行したときと同じ日時になる
+func writePostsSQL(w io.Writer, r *rand.Rand, output string, users int, kaomoji []string, images, width, height int) error {
+	base := time.Date(2016, time.January, 2, 0, 0, 0, 0, time.UTC).Add(time.Duration(10000-images) * time.Second)
+
+	for start := 0; start < images; start += generateInsertBatchSize {
+		fmt.Fprint(w, "INSERT INTO `posts` (`id`,`user_id`,`mime`,`imgdata`,`body`,`created_at`) VALUES ")
+		for j := start; j < images && j < start+generateInsertBatchSize; j++ {
+			i := j + 1
+
+			data, ext, mime, err := encodeGeneratedImage(i, generateImage(r, width, height))
+			if err != nil {
+				return err
+			}
+
+			err = os.WriteFile(filepath.Join(output, "img", fmt.Sprintf("%05d.%s", i, ext)), data, 0644)
+			if err != nil {
+				return err
+			}
+
+			if j > start {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, "(%d,%d,'%s',X'%s','%s','%s')",
+				i, 1+r.Intn(users), mime, hex.EncodeToString(data), escapeSQLString(kaomoji[r.Intn(len(kaomoji))]),
+				base.Add(time.Duration(i)*time.Second).Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintln(w, ";")
+	}
+
+	return nil
+}
+
+func writeCommentsSQL(w io.Writer, r *rand.Rand, users, posts int, kaomoji []string, comments int) {
+	base := time.Date(2016, time.January, 3, 0, 0, 0, 0, time.UTC)
+
+	for start := 0; start < comments; start += generateInsertBatchSize {
+		fmt.Fprint(w, "INSERT INTO `comments` (`id`,`post_id`,`user_id`,`comment`,`created_at`) VALUES ")
+		for j := start; j < comments && j < start+generateInsertBatchSize; j++ {
+			i := j + 1
+
+			if j > start {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintf(w, "(%d,%d,%d,'%s','%s')",
+				i, 1+r.Intn(posts), 1+r.Intn(users), escapeSQLString(kaomoji[r.Intn(len(kaomoji))]),
+				base.Add(time.Duration(i)*time.Second).Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintln(w, ";")
+	}
+}
+
+func escapeSQLString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// webappと同じパスワードハッシュ
+func calculatePasshash(accountName, password string) string {
+	salt := sha512.Sum512([]byte(accountName))
+	digest := sha512.Sum512([]byte(password + ":" + hex.EncodeToString(salt[:])))
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/catatsuy/private-isu/benchmarker/bench"
+)
+
+func TestGenerateUserdata_sameSeed(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	for _, dir := range []string{dir1, dir2} {
+		err := generateUserdata(dir, 60, 10, 20, 30, 8, 8, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir1, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgs, err := filepath.Glob(filepath.Join(dir1, "img", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 20 {
+		t.Errorf("expected 20 images, got %d", len(imgs))
+	}
+
+	for _, f := range append(files, imgs...) {
+		if info, err := os.Stat(f); err != nil || info.IsDir() {
+			continue
+		}
+		rel, _ := filepath.Rel(dir1, f)
+		b1, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := os.ReadFile(filepath.Join(dir2, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Errorf("%s differs with the same seed", rel)
+		}
+	}
+}
+
+func TestGenerateUserdata_manifestMatchesSQL(t *testing.T) {
+	dir := t.TempDir()
+	err := generateUserdata(dir, 120, 10, 20, 0, 8, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest bench.UserManifest
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sql, err := os.ReadFile(filepath.Join(dir, "seed.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (id,'account_name','passhash',authority,del_flg,'created_at')
+	rows := regexp.MustCompile(`\((\d+),'([^']+)','([0-9a-f]+)',(\d),(\d),'[^']+'\)`).FindAllStringSubmatch(string(sql), -1)
+
+	if len(rows) != len(manifest.Users) {
+		t.Fatalf("expected %d user rows, got %d", len(manifest.Users), len(rows))
+	}
+	for i, row := range rows {
+		u := manifest.Users[i]
+		if row[1] != strconv.Itoa(i+1) || row[2] != u.AccountName || row[3] != calculatePasshash(u.AccountName, u.Password) {
+			t.Errorf("row %d does not match the manifest: %v, %+v", i+1, row[1:3], u)
+		}
+
+		admin, banned := row[4] == "1", row[5] == "1"
+		if admin != (u.Role == bench.RoleAdmin) || banned != (u.Role == bench.RoleBanned) {
+			t.Errorf("row %d: authority=%s del_flg=%s does not match role %s", i+1, row[4], row[5], u.Role)
+		}
+	}
+}
+
+func TestValidateGenerateOptions(t *testing.T) {
+	tests := []struct {
+		name                                              string
+		users, sentences, images, comments, width, height int
+		ok                                                bool
+	}{
+		{"default", 1000, 1000, 500, 5000, 200, 150, true},
+		{"too few users", 49, 1000, 500, 5000, 200, 150, false},
+		{"users deleted by /initialize", 1001, 1000, 500, 5000, 200, 150, false},
+		{"fewer images than pages of indexMoreAndMore", 1000, 1000, 249, 5000, 200, 150, false},
+		{"enough images for indexMoreAndMore", 1000, 1000, 250, 5000, 200, 150, true},
+		{"posts deleted by /initialize", 1000, 1000, 10001, 5000, 200, 150, false},
+		{"comments deleted by /initialize", 1000, 1000, 500, 100001, 200, 150, false},
+		{"too small image", 1000, 1000, 500, 5000, 7, 150, false},
+	}
+
+	for _, tt := range tests {
+		err := validateGenerateOptions(tt.users, tt.sentences, tt.images, tt.comments, tt.width, tt.height)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -4,6 +4,8 @@ go 1.19
 
 require (
 	github.com/PuerkitoBio/goquery v1.9.2
+	github.com/andybalholm/brotli v1.0.6
+	github.com/klauspost/compress v1.16.7
 	github.com/marcw/cachecontrol v0.0.0-20140722115028-30341fe9a7d5
 )
 
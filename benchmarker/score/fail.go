@@ -11,25 +11,19 @@ type failErrors struct {
 	errs []error
 }
 
-var failInstance *failErrors
-var failOnce sync.Once
-
-func GetFailErrorsInstance() *failErrors {
-	failOnce.Do(func() {
-		errs := make([]error, 0)
-		failInstance = &failErrors{errs: errs}
+// メッセージ順に並べて同じメッセージをまとめたエラー
+// 記録した順はRawErrorsで使うので、並べ替えるのはコピーにする
+func (fes *failErrors) Errors() []error {
+	errs := fes.RawErrors()
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
 	})
 
-	return failInstance
-}
-
-func GetFailErrors() []error {
-	sort.Sort(GetFailErrorsInstance())
 	var tmp string
 	retErrs := make([]error, 0)
 
 	// 適当にuniqする
-	for _, e := range failInstance.errs {
+	for _, e := range errs {
 		if tmp != e.Error() {
 			tmp = e.Error()
 			retErrs = append(retErrs, e)
@@ -38,38 +32,33 @@ func GetFailErrors() []error {
 	return retErrs
 }
 
-func GetFailRawErrors() []error {
-	return GetFailErrorsInstance().errs
+// 記録した順のエラー。まとめずにすべて返す
+func (fes *failErrors) RawErrors() []error {
+	fes.RLock()
+	defer fes.RUnlock()
+
+	errs := make([]error, len(fes.errs))
+	copy(errs, fes.errs)
+	return errs
 }
 
-func GetFailErrorsStringSlice() []string {
+func (fes *failErrors) StringSlice() []string {
 	msgs := []string{}
-	for _, err := range GetFailErrors() {
+	for _, err := range fes.Errors() {
 		msgs = append(msgs, fmt.Sprint(err.Error()))
 	}
 	return msgs
 }
 
-func GetFailRawErrorsStringSlice() []string {
+// 記録した順のエラーメッセージ。まとめずにすべて返す
+func (fes *failErrors) RawStringSlice() []string {
 	msgs := []string{}
-	for _, err := range GetFailRawErrors() {
+	for _, err := range fes.RawErrors() {
 		msgs = append(msgs, fmt.Sprint(err.Error()))
 	}
 	return msgs
 }
 
-func (fes failErrors) Len() int {
-	return len(fes.errs)
-}
-
-func (fes failErrors) Swap(i, j int) {
-	fes.errs[i], fes.errs[j] = fes.errs[j], fes.errs[i]
-}
-
-func (fes failErrors) Less(i, j int) bool {
-	return fes.errs[i].Error() < fes.errs[j].Error()
-}
-
 func (fes *failErrors) Append(e error) {
 	fes.Lock()
 	fes.errs = append(fes.errs, e)
@@ -1,27 +1,135 @@
 package score
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
 
 type Score struct {
 	sync.RWMutex
 	score    int64
 	sucesses int64
 	fails    int64
+	// failsのうち、失格にするべき失敗の数
+	criticalFails int64
+
+	// 一時的な失敗で再試行した回数と、その種類ごとの内訳
+	retries        int64
+	retriesByClass map[string]int64
+	// 再試行したアクションのうち、再試行で回復したものとしなかったもの
+	retryRecovered int64
+	retryExhausted int64
+
+	cache CacheStats
+}
+
+type RetryStats struct {
+	Retries   int64            `json:"retries"`
+	ByClass   map[string]int64 `json:"by_class"`
+	Recovered int64            `json:"recovered"`
+	Exhausted int64            `json:"exhausted"`
+}
+
+// 静的ファイルや画像で、仮想ユーザーのブラウザのキャッシュがどう使われたか
+type CacheStats struct {
+	// 新鮮なキャッシュを使い、リクエストを送らなかった
+	Hits int64 `json:"hits"`
+	// 使えるキャッシュがなく、条件なしのリクエストを送った
+	Misses int64 `json:"misses"`
+	// 条件付きリクエストで再検証した
+	Revalidations int64 `json:"revalidations"`
+	// 再検証で304が返ってきた
+	NotModified int64 `json:"not_modified"`
+	// 容量を超えたために捨てた
+	Evictions int64 `json:"evictions"`
+	// 以前のキャッシュを持った状態で始めた仮想ユーザーの数
+	ReturningSessions int64 `json:"returning_sessions"`
+}
+
+// 1回のベンチマークの結果
+// 前の実行のシナリオが残っていても結果が混ざらないように、実行ごとにNewRecorderで作り、NewContextでシナリオに渡す
+type Recorder struct {
+	score            *Score
+	warmup           *Score
+	failErrors       *failErrors
+	warmupFailErrors *failErrors
+
+	// ウォームアップ中なら1
+	warmingUp int32
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		score:            &Score{},
+		warmup:           &Score{},
+		failErrors:       &failErrors{errs: make([]error, 0)},
+		warmupFailErrors: &failErrors{errs: make([]error, 0)},
+	}
 }
 
-var instance *Score
-var once sync.Once
+type recorderContextKey struct{}
+
+// どの実行にも属さないアクションの結果の記録先
+var defaultRecorder = NewRecorder()
+
+func NewContext(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderContextKey{}, r)
+}
 
-func GetInstance() *Score {
-	once.Do(func() {
-		instance = &Score{
-			score:    0,
-			sucesses: 0,
-			fails:    0,
-		}
-	})
+// ctxで渡された記録先を返す。なければどの実行にも属さない記録先を返す
+func FromContext(ctx context.Context) *Recorder {
+	if r, ok := ctx.Value(recorderContextKey{}).(*Recorder); ok {
+		return r
+	}
+	return defaultRecorder
+}
 
-	return instance
+// ウォームアップ後の結果
+func (r *Recorder) Score() *Score {
+	return r.score
+}
+
+// ウォームアップ中の結果。本番の得点には含めない
+func (r *Recorder) Warmup() *Score {
+	return r.warmup
+}
+
+// 今の結果を記録する先。ウォームアップ中ならWarmup、そうでなければScore
+func (r *Recorder) Current() *Score {
+	if r.IsWarmingUp() {
+		return r.warmup
+	}
+	return r.score
+}
+
+func (r *Recorder) FailErrors() *failErrors {
+	return r.failErrors
+}
+
+// ウォームアップ中のエラー
+func (r *Recorder) WarmupFailErrors() *failErrors {
+	return r.warmupFailErrors
+}
+
+// 今のエラーを記録する先。ウォームアップ中ならWarmupFailErrors、そうでなければFailErrors
+func (r *Recorder) CurrentFailErrors() *failErrors {
+	if r.IsWarmingUp() {
+		return r.warmupFailErrors
+	}
+	return r.failErrors
+}
+
+func (r *Recorder) StartWarmup() {
+	atomic.StoreInt32(&r.warmingUp, 1)
+}
+
+func (r *Recorder) EndWarmup() {
+	atomic.StoreInt32(&r.warmingUp, 0)
+}
+
+func (r *Recorder) IsWarmingUp() bool {
+	return atomic.LoadInt32(&r.warmingUp) == 1
 }
 
 func (s *Score) GetScore() int64 {
@@ -49,6 +157,90 @@ func (s *Score) GetFails() int64 {
 	return fails
 }
 
+func (s *Score) GetCriticalFails() int64 {
+	s.RLock()
+	criticalFails := s.criticalFails
+	s.RUnlock()
+	return criticalFails
+}
+
+func (s *Score) GetRetryStats() RetryStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	byClass := map[string]int64{}
+	for class, n := range s.retriesByClass {
+		byClass[class] = n
+	}
+	return RetryStats{
+		Retries:   s.retries,
+		ByClass:   byClass,
+		Recovered: s.retryRecovered,
+		Exhausted: s.retryExhausted,
+	}
+}
+
+// 再試行は成功や失敗の数に含めず、別に数える
+func (s *Score) SetRetry(class string, point int64) {
+	s.Lock()
+	s.score -= point
+	s.retries += 1
+	if s.retriesByClass == nil {
+		s.retriesByClass = map[string]int64{}
+	}
+	s.retriesByClass[class] += 1
+	s.Unlock()
+}
+
+func (s *Score) SetRetryResult(recovered bool) {
+	s.Lock()
+	if recovered {
+		s.retryRecovered += 1
+	} else {
+		s.retryExhausted += 1
+	}
+	s.Unlock()
+}
+
+func (s *Score) GetCacheStats() CacheStats {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cache
+}
+
+func (s *Score) SetCacheHit() {
+	s.Lock()
+	s.cache.Hits += 1
+	s.Unlock()
+}
+
+func (s *Score) SetCacheMiss() {
+	s.Lock()
+	s.cache.Misses += 1
+	s.Unlock()
+}
+
+func (s *Score) SetCacheRevalidation(notModified bool) {
+	s.Lock()
+	s.cache.Revalidations += 1
+	if notModified {
+		s.cache.NotModified += 1
+	}
+	s.Unlock()
+}
+
+func (s *Score) SetCacheEvictions(n int) {
+	s.Lock()
+	s.cache.Evictions += int64(n)
+	s.Unlock()
+}
+
+func (s *Score) SetReturningSession() {
+	s.Lock()
+	s.cache.ReturningSessions += 1
+	s.Unlock()
+}
+
 func (s *Score) SetScore(point int64) {
 	s.Lock()
 	s.score += point
@@ -62,3 +254,11 @@ func (s *Score) SetFails(point int64) {
 	s.fails += 1
 	s.Unlock()
 }
+
+func (s *Score) SetCriticalFails(point int64) {
+	s.Lock()
+	s.score -= point
+	s.fails += 1
+	s.criticalFails += 1
+	s.Unlock()
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Durations は並列に記録される所要時間を集計する
+type Durations struct {
+	sync.Mutex
+	values []time.Duration
+}
+
+// DurationSummary はミリ秒単位の集計結果
+type DurationSummary struct {
+	Count int64   `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func (d *Durations) Add(v time.Duration) {
+	d.Lock()
+	d.values = append(d.values, v)
+	d.Unlock()
+}
+
+func (d *Durations) Summary() DurationSummary {
+	d.Lock()
+	values := make([]time.Duration, len(d.values))
+	copy(values, d.values)
+	d.Unlock()
+
+	if len(values) == 0 {
+		return DurationSummary{}
+	}
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var sum time.Duration
+	for _, v := range values {
+		sum += v
+	}
+
+	percentile := func(p float64) float64 {
+		return milliseconds(values[int(float64(len(values)-1)*p)])
+	}
+
+	return DurationSummary{
+		Count: int64(len(values)),
+		Mean:  milliseconds(sum / time.Duration(len(values))),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   milliseconds(values[len(values)-1]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}